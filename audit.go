@@ -0,0 +1,197 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/oklog/ulid/v2"
+	"go.uber.org/zap"
+)
+
+// AuditTopic is the reserved topic of the audit records, which are only
+// fetched by iterators of the topic itself.
+const AuditTopic = "$audit"
+
+// audited actions
+const (
+	ActionIteratorCreate = "iterator.create"
+	ActionIteratorClose  = "iterator.close"
+	ActionEventDelete    = "event.delete"
+	ActionEventsDelete   = "events.delete"
+	ActionEventRedact    = "event.redact"
+	ActionSubjectShred   = "subject.shred"
+	ActionReplicaPromote = "replica.promote"
+	ActionConfigReload   = "config.reload"
+)
+
+const anonymous = "anonymous"
+
+var (
+	ErrReservedTopic = errors.New("reserved topic")
+)
+
+// IsReservedTopic reports whether the topic is reserved for the events of the
+// store itself, i.e. it starts with a $.
+func IsReservedTopic(topic string) bool {
+	return strings.HasPrefix(topic, "$")
+}
+
+// Actor is the principal and client performing an operation.
+type Actor struct {
+	Principal  string `json:"principal"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// Client identifies the client of the actor: its principal, or its address
+// when anonymous, e.g. without auth.
+func (a Actor) Client() string {
+	if a.Principal == anonymous && a.RemoteAddr != "" {
+		return a.RemoteAddr
+	}
+
+	return a.Principal
+}
+
+type actorKey struct{}
+
+func ContextWithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor of the context; the principal is
+// anonymous when unknown.
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	if actor.Principal == "" {
+		actor.Principal = anonymous
+	}
+
+	return actor
+}
+
+// AuditRecord is the payload of an audit event.
+type AuditRecord struct {
+	Action string `json:"action"`
+	Actor
+	Details map[string]any `json:"details,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type Auditor interface {
+	Audit(record *AuditRecord) error
+}
+
+// NewAuditor returns an auditor storing the records as events of the audit
+// topic.
+func NewAuditor(repo Repository) Auditor {
+	return &auditor{
+		log: zap.L().With(
+			zap.String("service", "audit"),
+		),
+		events: repo,
+	}
+}
+
+type auditor struct {
+	log    *zap.Logger
+	events Repository
+}
+
+func (a *auditor) Audit(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := a.events.Store(NewEvent(AuditTopic, NewPayloadFromJSON(data))); err != nil {
+		a.log.Error(err.Error(),
+			zap.String("action", record.Action),
+			zap.String("principal", record.Principal),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// AuditMiddleware records the action of the endpoint with the actor of the
+// context, whether it succeeded or not. A failed record is logged by the
+// auditor, but does not fail the request: the action has been performed, and
+// e.g. the ID of a created iterator must reach its client.
+func AuditMiddleware(auditor Auditor, action string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			response, err := next(ctx, request)
+
+			record := &AuditRecord{
+				Action:  action,
+				Actor:   ActorFromContext(ctx),
+				Details: auditDetails(action, request, response),
+			}
+
+			if err != nil {
+				record.Error = err.Error()
+			}
+
+			auditor.Audit(record)
+
+			return response, err
+		}
+	}
+}
+
+func auditDetails(action string, request any, response any) map[string]any {
+	details := make(map[string]any)
+
+	switch req := request.(type) {
+	case NewIteratorRequest:
+		details["topic"] = req.Topic
+		details["since"] = req.Since
+
+		if id, ok := response.(string); ok {
+			details["iterator"] = id
+		}
+
+	case DeleteEventsRequest:
+		details["topic"] = req.Topic
+		details["from"] = req.From
+		details["to"] = req.To
+
+		if n, ok := response.(int); ok {
+			details["deleted"] = n
+		}
+
+	case RedactEventRequest:
+		details["id"] = req.ID.String()
+		details["reason"] = req.Reason
+
+	case ulid.ULID:
+		details["id"] = req.String()
+
+	case string:
+		switch action {
+		case ActionIteratorClose:
+			details["iterator"] = req
+		case ActionSubjectShred:
+			details["subject"] = req
+		default:
+			details["target"] = req
+		}
+
+	case map[string]any:
+		for k, v := range req {
+			details[k] = v
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+
+	return details
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubRepository struct {
+	Repository
+	stored []*Event
+}
+
+func (repo *stubRepository) Store(e *Event) error {
+	repo.stored = append(repo.stored, e)
+	return nil
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := new(stubRepository)
+	auditor := NewAuditor(repo)
+
+	ctx := ContextWithActor(context.Background(), Actor{
+		Principal:  "alice",
+		RemoteAddr: "10.0.0.1",
+	})
+
+	create := AuditMiddleware(auditor, ActionIteratorCreate)(
+		func(ctx context.Context, request any) (any, error) {
+			return "01HJJD04ZSE4T4SN6T7SVYBPNV", nil
+		})
+
+	id, err := create(ctx, NewIteratorRequest{Topic: "hello.*"})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("01HJJD04ZSE4T4SN6T7SVYBPNV", id)
+
+	// failures are audited too, without a known principal
+	closeIterator := AuditMiddleware(auditor, ActionIteratorClose)(
+		func(ctx context.Context, request any) (any, error) {
+			return nil, ErrIteratorNotFound
+		})
+
+	_, err = closeIterator(context.Background(), "01HJJD04ZSE4T4SN6T7SVYBPNV")
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	if !assert.Len(repo.stored, 2) {
+		return
+	}
+
+	records := make([]*AuditRecord, len(repo.stored))
+	for i, e := range repo.stored {
+		assert.Equal(AuditTopic, e.Topic)
+
+		raw, ok := e.Payload.JSON()
+		if !assert.True(ok) {
+			return
+		}
+
+		var record *AuditRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		records[i] = record
+	}
+
+	assert.Equal(ActionIteratorCreate, records[0].Action)
+	assert.Equal("alice", records[0].Principal)
+	assert.Equal("10.0.0.1", records[0].RemoteAddr)
+	assert.Equal("hello.*", records[0].Details["topic"])
+	assert.Equal("01HJJD04ZSE4T4SN6T7SVYBPNV", records[0].Details["iterator"])
+	assert.Empty(records[0].Error)
+
+	assert.Equal(ActionIteratorClose, records[1].Action)
+	assert.Equal(anonymous, records[1].Principal)
+	assert.Equal("01HJJD04ZSE4T4SN6T7SVYBPNV", records[1].Details["iterator"])
+	assert.Equal(ErrIteratorNotFound.Error(), records[1].Error)
+}
+
+type failingAuditor struct{}
+
+func (failingAuditor) Audit(record *AuditRecord) error {
+	return errors.New("audit failed")
+}
+
+func TestAuditMiddlewareFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoint := AuditMiddleware(failingAuditor{}, ActionEventDelete)(
+		func(ctx context.Context, request any) (any, error) {
+			return nil, nil
+		})
+
+	// the action has been performed, so it does not fail
+	_, err := endpoint(context.Background(), nil)
+	assert.NoError(err)
+}
+
+func TestStoreEndpointReservedTopic(t *testing.T) {
+	assert := assert.New(t)
+
+	next := new(stubService)
+	endpoint := StoreEndpoint(next)
+
+	_, err := endpoint(context.Background(), StoreRequest{
+		Topic:   AuditTopic,
+		Payload: NewPayload("Hello World"),
+	})
+
+	assert.ErrorIs(err, ErrReservedTopic)
+	assert.Empty(next.stored)
+}
+
+func TestActorClient(t *testing.T) {
+	assert := assert.New(t)
+
+	// clients without auth are told apart by their addresses
+	actor := ActorFromContext(ContextWithActor(context.Background(), Actor{
+		RemoteAddr: "10.0.0.1",
+	}))
+
+	assert.Equal(anonymous, actor.Principal)
+	assert.Equal("10.0.0.1", actor.Client())
+
+	actor.Principal = "alice"
+	assert.Equal("alice", actor.Client())
+
+	assert.Equal(anonymous, ActorFromContext(context.Background()).Client())
+}
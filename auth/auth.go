@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/mirror520/events"
+)
+
+// credential schemes
+const (
+	SchemeAPIKey = "ApiKey"
+	SchemeBearer = "Bearer"
+)
+
+var (
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrMissingCredential = fmt.Errorf("%w: missing credential", ErrUnauthorized)
+	ErrInvalidCredential = fmt.Errorf("%w: invalid credential", ErrUnauthorized)
+	ErrUnsupportedScheme = fmt.Errorf("%w: unsupported scheme", ErrUnauthorized)
+)
+
+// Credential is the credential presented by a client, e.g. an API key or a
+// bearer token.
+type Credential struct {
+	Scheme string
+	Token  string
+}
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name   string         `json:"name"`
+	Method string         `json:"method"` // api_key or jwt
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+type credentialKey struct{}
+
+type principalKey struct{}
+
+// ContextWithCredential sets the credential of a request, to be verified by
+// the Middleware of the endpoints.
+func ContextWithCredential(ctx context.Context, cred Credential) context.Context {
+	return context.WithValue(ctx, credentialKey{}, cred)
+}
+
+func CredentialFromContext(ctx context.Context) (Credential, bool) {
+	cred, ok := ctx.Value(credentialKey{}).(Credential)
+	return cred, ok
+}
+
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal authenticated by the Middleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+type Authenticator interface {
+	Authenticate(cred Credential) (*Principal, error)
+}
+
+// NewAuthenticator returns an authenticator of the API keys and, when
+// configured, of JWT bearer tokens verified with the keys of a JWKS file.
+func NewAuthenticator(cfg events.Auth) (Authenticator, error) {
+	a := &authenticator{
+		apiKeys: make(map[[sha256.Size]byte]string),
+	}
+
+	for _, key := range cfg.APIKeys {
+		if key.Key == "" || key.Principal == "" {
+			return nil, errors.New("api key requires a key and a principal")
+		}
+
+		a.apiKeys[sha256.Sum256([]byte(key.Key))] = key.Principal
+	}
+
+	if cfg.JWT != nil {
+		verifier, err := NewJWTVerifier(*cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+
+		a.jwt = verifier
+	}
+
+	return a, nil
+}
+
+type authenticator struct {
+	apiKeys map[[sha256.Size]byte]string // sha256 of the key -> principal
+	jwt     *JWTVerifier
+}
+
+func (a *authenticator) Authenticate(cred Credential) (*Principal, error) {
+	switch {
+	case strings.EqualFold(cred.Scheme, SchemeAPIKey):
+		// keys are looked up by their hashes, so the time of the lookup
+		// reveals nothing about the keys
+		name, ok := a.apiKeys[sha256.Sum256([]byte(cred.Token))]
+		if !ok {
+			return nil, ErrInvalidCredential
+		}
+
+		return &Principal{
+			Name:   name,
+			Method: "api_key",
+		}, nil
+
+	case strings.EqualFold(cred.Scheme, SchemeBearer) && a.jwt != nil:
+		return a.jwt.Verify(cred.Token)
+
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+}
+
+// NamespaceMiddleware admits the principals of the namespace, and forbids
+// any other; it follows the Middleware, which sets the principal.
+func NamespaceMiddleware(ns events.Namespace) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			p, ok := PrincipalFromContext(ctx)
+			if !ok {
+				return nil, ErrMissingCredential
+			}
+
+			if !ns.Admits(p.Name) {
+				return nil, fmt.Errorf("%w: namespace %q", events.ErrForbidden, ns.Name)
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// Middleware authenticates the credential of the context, and sets the
+// principal on the context of the endpoint, also as the principal of the
+// audited actor. With a policy, the permissions of the principal are set as
+// well, which endpoints enforce.
+func Middleware(authenticator Authenticator, policy *Policy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			cred, ok := CredentialFromContext(ctx)
+			if !ok {
+				return nil, ErrMissingCredential
+			}
+
+			p, err := authenticator.Authenticate(cred)
+			if err != nil {
+				return nil, err
+			}
+
+			actor := events.ActorFromContext(ctx)
+			actor.Principal = p.Name
+
+			ctx = ContextWithPrincipal(ctx, p)
+			ctx = events.ContextWithActor(ctx, actor)
+
+			if policy != nil {
+				ctx = events.ContextWithPermissions(ctx, policy.Permissions(p))
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
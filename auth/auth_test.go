@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func writeJWKS(t *testing.T, name string, keys ...jose.JSONWebKey) {
+	data, err := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signToken(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.Claims) string {
+	opts := (&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), kid)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, jose.JSONWebKey{Key: key.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"})
+
+	authenticator, err := NewAuthenticator(events.Auth{
+		APIKeys: []events.APIKey{
+			{Principal: "producer", Key: "secret"},
+		},
+		JWT: &events.JWT{
+			JWKS:     jwks,
+			Issuer:   "https://auth.example.com/",
+			Audience: "events",
+		},
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// api keys
+	p, err := authenticator.Authenticate(Credential{SchemeAPIKey, "secret"})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("producer", p.Name)
+	assert.Equal("api_key", p.Method)
+
+	_, err = authenticator.Authenticate(Credential{SchemeAPIKey, "wrong"})
+	assert.ErrorIs(err, ErrInvalidCredential)
+
+	_, err = authenticator.Authenticate(Credential{"Basic", "secret"})
+	assert.ErrorIs(err, ErrUnsupportedScheme)
+
+	// bearer tokens
+	claims := jwt.Claims{
+		Subject:  "consumer",
+		Issuer:   "https://auth.example.com/",
+		Audience: jwt.Audience{"events"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	p, err = authenticator.Authenticate(Credential{SchemeBearer, signToken(t, key, "k1", claims)})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("consumer", p.Name)
+	assert.Equal("jwt", p.Method)
+
+	expired := claims
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	_, err = authenticator.Authenticate(Credential{SchemeBearer, signToken(t, key, "k1", expired)})
+	assert.ErrorIs(err, ErrUnauthorized)
+
+	other := claims
+	other.Audience = jwt.Audience{"other"}
+
+	_, err = authenticator.Authenticate(Credential{SchemeBearer, signToken(t, key, "k1", other)})
+	assert.ErrorIs(err, ErrUnauthorized)
+
+	unexpiring := claims
+	unexpiring.Expiry = nil
+
+	_, err = authenticator.Authenticate(Credential{SchemeBearer, signToken(t, key, "k1", unexpiring)})
+	assert.ErrorIs(err, ErrUnauthorized)
+
+	for _, issuer := range []string{"", "https://other.example.com/"} {
+		issued := claims
+		issued.Issuer = issuer
+
+		_, err = authenticator.Authenticate(Credential{SchemeBearer, signToken(t, key, "k1", issued)})
+		assert.ErrorIs(err, ErrUnauthorized, issuer)
+	}
+
+	// a rotated key is loaded from the changed file
+	rotated, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	token := signToken(t, rotated, "k2", claims)
+
+	_, err = authenticator.Authenticate(Credential{SchemeBearer, token})
+	assert.ErrorIs(err, ErrUnauthorized)
+
+	writeJWKS(t, jwks,
+		jose.JSONWebKey{Key: key.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"},
+		jose.JSONWebKey{Key: rotated.Public(), KeyID: "k2", Algorithm: "ES256", Use: "sig"},
+	)
+
+	future := time.Now().Add(time.Second)
+	os.Chtimes(jwks, future, future)
+
+	p, err = authenticator.Authenticate(Credential{SchemeBearer, token})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("consumer", p.Name)
+
+	// a token signed by an unknown key with the kid of a known key
+	forged := signToken(t, rotated, "k1", claims)
+
+	_, err = authenticator.Authenticate(Credential{SchemeBearer, forged})
+	assert.ErrorIs(err, ErrInvalidCredential)
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	authenticator, err := NewAuthenticator(events.Auth{
+		APIKeys: []events.APIKey{
+			{Principal: "producer", Key: "secret"},
+		},
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var (
+		principal *Principal
+		actor     events.Actor
+	)
+
+	endpoint := Middleware(authenticator, nil)(func(ctx context.Context, request any) (any, error) {
+		principal, _ = PrincipalFromContext(ctx)
+		actor = events.ActorFromContext(ctx)
+		return nil, nil
+	})
+
+	_, err = endpoint(context.Background(), nil)
+	assert.ErrorIs(err, ErrMissingCredential)
+
+	ctx := events.ContextWithActor(context.Background(), events.Actor{RemoteAddr: "10.0.0.1"})
+	ctx = ContextWithCredential(ctx, Credential{SchemeAPIKey, "secret"})
+
+	if _, err := endpoint(ctx, nil); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	if assert.NotNil(principal) {
+		assert.Equal("producer", principal.Name)
+	}
+
+	assert.Equal("producer", actor.Principal)
+	assert.Equal("10.0.0.1", actor.RemoteAddr)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/mirror520/events"
+)
+
+// signatureAlgorithms are the accepted algorithms of tokens; symmetric
+// algorithms are not, since the keys of a JWKS file are public.
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTVerifier verifies JWT bearer tokens against the keys of a local JWKS
+// file. The file is read again when a token is signed by an unknown key and
+// the file has changed, so keys are rotated without a restart.
+type JWTVerifier struct {
+	cfg events.JWT
+
+	keys    *jose.JSONWebKeySet
+	modTime time.Time
+	sync.RWMutex
+}
+
+func NewJWTVerifier(cfg events.JWT) (*JWTVerifier, error) {
+	if cfg.JWKS == "" {
+		return nil, errors.New("jwt requires a jwks file")
+	}
+
+	if cfg.Claim == "" {
+		cfg.Claim = "sub"
+	}
+
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = jwt.DefaultLeeway
+	}
+
+	v := &JWTVerifier{cfg: cfg}
+	if _, err := v.load(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// load reads the JWKS file if it has changed, and reports whether it has.
+func (v *JWTVerifier) load() (bool, error) {
+	info, err := os.Stat(v.cfg.JWKS)
+	if err != nil {
+		return false, err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if info.ModTime().Equal(v.modTime) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(v.cfg.JWKS)
+	if err != nil {
+		return false, err
+	}
+
+	var keys *jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return false, err
+	}
+
+	v.keys = keys
+	v.modTime = info.ModTime()
+
+	return true, nil
+}
+
+func (v *JWTVerifier) key(kid string) (*jose.JSONWebKey, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		v.RLock()
+		keys := v.keys.Key(kid)
+		v.RUnlock()
+
+		if len(keys) > 0 {
+			return &keys[0], nil
+		}
+
+		if changed, err := v.load(); err != nil || !changed {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown key %q", ErrUnauthorized, kid)
+}
+
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	tok, err := jwt.ParseSigned(token, signatureAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	if len(tok.Headers) == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	key, err := v.key(tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		claims jwt.Claims
+		custom map[string]any
+	)
+
+	if err := tok.Claims(key.Key, &claims, &custom); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	expected := jwt.Expected{
+		Issuer: v.cfg.Issuer,
+		Time:   time.Now(),
+	}
+
+	if v.cfg.Audience != "" {
+		expected.AnyAudience = jwt.Audience{v.cfg.Audience}
+	}
+
+	if err := claims.ValidateWithLeeway(expected, v.cfg.Leeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	// tokens never expiring are not accepted
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("%w: missing claim %q", ErrUnauthorized, "exp")
+	}
+
+	name, _ := custom[v.cfg.Claim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing claim %q", ErrInvalidCredential, v.cfg.Claim)
+	}
+
+	return &Principal{
+		Name:   name,
+		Method: "jwt",
+		Claims: custom,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/mirror520/events"
+)
+
+// Policy grants principals the permission to publish to and subscribe to
+// topic patterns, and to administer the store. A principal not granted a
+// topic, or the admin permission, by any rule is denied.
+type Policy struct {
+	rules []events.ACLRule
+}
+
+func NewPolicy(rules []events.ACLRule) *Policy {
+	return &Policy{rules}
+}
+
+// Permissions returns the permissions of the principal, combined from every
+// rule naming the principal, or "*" for any principal.
+func (p *Policy) Permissions(principal *Principal) events.Permissions {
+	perms := new(permissions)
+
+	for _, rule := range p.rules {
+		if !rule.Applies(principal.Name) {
+			continue
+		}
+
+		perms.publish = append(perms.publish, rule.Publish...)
+		perms.subscribe = append(perms.subscribe, rule.Subscribe...)
+		perms.admin = perms.admin || rule.Admin
+	}
+
+	return perms
+}
+
+type permissions struct {
+	publish   []string
+	subscribe []string
+	admin     bool
+}
+
+func (perms *permissions) CanPublish(topic string) bool {
+	return matchAny(perms.publish, topic)
+}
+
+func (perms *permissions) CanSubscribe(topic string) bool {
+	if topic == "" {
+		return len(perms.subscribe) > 0
+	}
+
+	return matchAny(perms.subscribe, topic)
+}
+
+func (perms *permissions) CanAdminister() bool {
+	return perms.admin
+}
+
+func matchAny(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if events.MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
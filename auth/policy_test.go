@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func TestPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := NewPolicy([]events.ACLRule{
+		{
+			Principals: []string{"producer"},
+			Publish:    []string{"sensors/**"},
+		},
+		{
+			Principals: []string{"*"},
+			Subscribe:  []string{"sensors/*"},
+		},
+		{
+			Principals: []string{"auditor"},
+			Subscribe:  []string{"$audit"},
+		},
+		{
+			Principals: []string{"operator"},
+			Admin:      true,
+		},
+	})
+
+	producer := policy.Permissions(&Principal{Name: "producer"})
+	assert.True(producer.CanPublish("sensors/a/temperature"))
+	assert.False(producer.CanPublish("logs/app"))
+	assert.True(producer.CanSubscribe("sensors/a"))
+	assert.True(producer.CanSubscribe(""))
+
+	auditor := policy.Permissions(&Principal{Name: "auditor"})
+	assert.False(auditor.CanPublish("sensors/a"))
+	assert.True(auditor.CanSubscribe(events.AuditTopic))
+	assert.False(auditor.CanAdminister())
+	assert.True(auditor.CanSubscribe("sensors/a"))
+	assert.False(auditor.CanSubscribe("sensors/a/temperature"))
+
+	operator := policy.Permissions(&Principal{Name: "operator"})
+	assert.True(operator.CanAdminister())
+	assert.False(operator.CanSubscribe("logs/app"))
+
+	// without rules, nothing is allowed
+	none := NewPolicy(nil).Permissions(&Principal{Name: "producer"})
+	assert.False(none.CanPublish("sensors/a"))
+	assert.False(none.CanSubscribe(""))
+}
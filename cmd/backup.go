@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/badger"
+)
+
+const backupManifestFile = "manifest.json"
+
+func backupFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dsn",
+			Usage: "Overrides the Badger DSN of the config",
+		},
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "Specifies the backup directory; defaults to backups in the working directory",
+		},
+	}
+}
+
+func backupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Backs up the Badger database, incrementally since the last backup of the directory",
+		Flags: append(backupFlags(),
+			&cli.BoolFlag{
+				Name:  "full",
+				Usage: "Takes a full backup, which starts a new chain of incremental backups",
+			},
+		),
+		Action: backupEvents,
+	}
+}
+
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Restores the last chain of backups into an empty Badger database; run it while the service is stopped",
+		Flags: append(backupFlags(),
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "Restores to a point in time, given as an RFC 3339 timestamp or a ULID, by truncating later events",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Restores into a database which is not empty",
+			},
+		),
+		Action: restoreEvents,
+	}
+}
+
+type backupEntry struct {
+	File    string    `json:"file"`
+	Since   uint64    `json:"since"`
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// backupManifest tracks the versions of the backups of a directory. A full
+// backup has a since of zero; the incremental backups after it form a chain,
+// each starting at the version after the one of its predecessor.
+type backupManifest struct {
+	Backups []*backupEntry `json:"backups"`
+}
+
+// chain returns the last full backup and the incremental backups after it.
+func (m *backupManifest) chain() []*backupEntry {
+	for i := len(m.Backups) - 1; i >= 0; i-- {
+		if m.Backups[i].Since == 0 {
+			return m.Backups[i:]
+		}
+	}
+
+	return nil
+}
+
+func readManifest(dir string) (*backupManifest, error) {
+	m := new(backupManifest)
+
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// writeManifest replaces the file atomically, so a crash never leaves it
+// empty.
+func writeManifest(dir string, m *backupManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, backupManifestFile)
+
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func badgerConfig(cli *cli.Context) (events.Persistence, error) {
+	var driver string
+	if cli.String("dsn") != "" {
+		driver = string(events.BadgerDB)
+	}
+
+	return persistenceConfig(cli, driver, cli.String("dsn"))
+}
+
+// openBadger opens the Badger database of the config, without encryption:
+// backups hold the payloads as stored.
+func openBadger(cli *cli.Context) (badger.EventRepository, string, error) {
+	cfg, err := badgerConfig(cli)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cfg.Driver != events.BadgerDB {
+		return nil, "", errors.New("backup requires the badger driver")
+	}
+
+	dir := cli.String("dir")
+	if dir == "" {
+		path := cli.String("path")
+		if path == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, "", err
+			}
+
+			path = homeDir + "/.events"
+		}
+
+		dir = filepath.Join(path, "backups")
+	}
+
+	repo, err := badger.NewEventRepository(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return repo.(badger.EventRepository), dir, nil
+}
+
+func backupEvents(cli *cli.Context) error {
+	repo, dir, err := openBadger(cli)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var since uint64
+	if chain := m.chain(); len(chain) > 0 && !cli.Bool("full") {
+		since = chain[len(chain)-1].Version + 1
+	}
+
+	now := time.Now().UTC()
+	entry := &backupEntry{
+		File:  fmt.Sprintf("backup-%s-%d.bak", now.Format("20060102T150405"), since),
+		Since: since,
+		Time:  now,
+	}
+
+	f, err := os.Create(filepath.Join(dir, entry.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, err := repo.Backup(f, since)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// nothing changed since the last backup
+	if since > 0 && version < since {
+		version = since - 1
+	}
+
+	entry.Version = version
+
+	m.Backups = append(m.Backups, entry)
+	if err := writeManifest(dir, m); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cli.App.ErrWriter, "%s written, since version %d to %d\n", entry.File, since, version)
+	return nil
+}
+
+// parseUntil parses a point in time given as a ULID, or as an RFC 3339
+// timestamp, which is turned into the greatest ULID of its millisecond.
+func parseUntil(until string) (ulid.ULID, error) {
+	if id, err := ulid.ParseStrict(until); err == nil {
+		return id, nil
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, until)
+	if err != nil {
+		return ulid.ULID{}, fmt.Errorf("invalid point in time %q, expected a ULID or an RFC 3339 timestamp", until)
+	}
+
+	var id ulid.ULID
+	if err := id.SetTime(ulid.Timestamp(ts)); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	for i := 6; i < len(id); i++ {
+		id[i] = 0xFF
+	}
+
+	return id, nil
+}
+
+func restoreEvents(cli *cli.Context) error {
+	var (
+		until    ulid.ULID
+		truncate bool
+	)
+
+	if s := cli.String("until"); s != "" {
+		id, err := parseUntil(s)
+		if err != nil {
+			return err
+		}
+
+		until, truncate = id, true
+	}
+
+	cfg, err := badgerConfig(cli)
+	if err != nil {
+		return err
+	}
+
+	path, _, _ := strings.Cut(cfg.DSN, "?")
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 && !cli.Bool("force") {
+		return errors.New("database not empty, restore with --force to merge into it")
+	}
+
+	repo, dir, err := openBadger(cli)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	chain := m.chain()
+	if len(chain) == 0 {
+		return errors.New("no full backup found")
+	}
+
+	for i, entry := range chain {
+		if i > 0 && entry.Since != chain[i-1].Version+1 {
+			return fmt.Errorf("%s does not continue %s", entry.File, chain[i-1].File)
+		}
+
+		f, err := os.Open(filepath.Join(dir, entry.File))
+		if err != nil {
+			return err
+		}
+
+		err = repo.Load(f)
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cli.App.ErrWriter, "%s loaded\n", entry.File)
+	}
+
+	if truncate {
+		n, err := repo.Truncate(until)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cli.App.ErrWriter, "%d events after %s truncated\n", n, until)
+	}
+
+	return nil
+}
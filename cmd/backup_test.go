@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/badger"
+)
+
+func TestBackupRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	backups := filepath.Join(dir, "backups")
+
+	run := func(args ...string) error {
+		app := newApp()
+		app.Writer = new(bytes.Buffer)
+		app.ErrWriter = new(bytes.Buffer)
+
+		return app.Run(append([]string{"events", "--path", dir}, args...))
+	}
+
+	open := func(dsn string, namespace string) badger.EventRepository {
+		repo, err := badger.NewEventRepository(events.Persistence{
+			Driver:    events.BadgerDB,
+			DSN:       dsn,
+			Namespace: namespace,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return repo.(badger.EventRepository)
+	}
+
+	now := time.Now()
+	ids := make([]ulid.ULID, 5)
+	for i := range ids {
+		ids[i] = ulid.Make()
+		ids[i].SetTime(ulid.Timestamp(now.Add(time.Duration(i-5) * time.Minute)))
+	}
+
+	// the backups hold every namespace of the database
+	repo := open(src, "")
+	acme := open(src, "acme")
+	for _, id := range ids[:3] {
+		repo.Store(events.NewEvent("hello.world", events.NewPayload(id.String()), id))
+		acme.Store(events.NewEvent("hello.world", events.NewPayload(id.String()), id))
+	}
+	acme.Close()
+	repo.Close()
+
+	if !assert.NoError(run("backup", "--dsn", src, "--dir", backups)) {
+		return
+	}
+
+	// the incremental backup holds the new events and the deletion
+	repo = open(src, "")
+	acme = open(src, "acme")
+	for _, id := range ids[3:] {
+		repo.Store(events.NewEvent("hello.world", events.NewPayload(id.String()), id))
+		acme.Store(events.NewEvent("hello.world", events.NewPayload(id.String()), id))
+	}
+	repo.(events.Eraser).Delete(ids[0])
+	acme.Close()
+	repo.Close()
+
+	if !assert.NoError(run("backup", "--dsn", src, "--dir", backups)) {
+		return
+	}
+
+	m, err := readManifest(backups)
+	if assert.NoError(err) && assert.Len(m.Backups, 2) {
+		assert.Zero(m.Backups[0].Since)
+		assert.Equal(m.Backups[0].Version+1, m.Backups[1].Since)
+	}
+
+	restored := func(dsn string, namespace string) []ulid.ULID {
+		repo := open(dsn, namespace)
+		defer repo.Close()
+
+		result := make([]ulid.ULID, 0)
+		iterate(context.Background(), repo, time.Time{}, 100, time.Second,
+			func(e *events.Event) error {
+				result = append(result, e.ID)
+				return nil
+			})
+
+		return result
+	}
+
+	full := filepath.Join(dir, "full")
+	if assert.NoError(run("restore", "--dsn", full, "--dir", backups)) {
+		assert.Equal(ids[1:], restored(full, ""))
+		assert.Equal(ids, restored(full, "acme"))
+	}
+
+	// point in time, as a ULID
+	pit := filepath.Join(dir, "pit")
+	if assert.NoError(run("restore", "--dsn", pit, "--dir", backups, "--until", ids[2].String())) {
+		assert.Equal(ids[1:3], restored(pit, ""))
+		assert.Equal(ids[:3], restored(pit, "acme"))
+	}
+
+	// restoring into a database which is not empty requires --force
+	assert.Error(run("restore", "--dsn", pit, "--dir", backups))
+}
+
+func TestParseUntil(t *testing.T) {
+	assert := assert.New(t)
+
+	id := ulid.Make()
+
+	until, err := parseUntil(id.String())
+	assert.NoError(err)
+	assert.Equal(id, until)
+
+	until, err = parseUntil("2024-01-02T03:04:05.678Z")
+	if assert.NoError(err) {
+		ts := time.Date(2024, 1, 2, 3, 4, 5, 678000000, time.UTC)
+		assert.Equal(ulid.Timestamp(ts), until.Time())
+
+		// the greatest ULID of the millisecond
+		var next ulid.ULID
+		next.SetTime(ulid.Timestamp(ts) + 1)
+		assert.Equal(-1, until.Compare(next))
+		assert.Equal(1, until.Compare(ulid.MustNew(ulid.Timestamp(ts), bytes.NewReader(bytes.Repeat([]byte{0xFE}, 10)))))
+	}
+
+	_, err = parseUntil("yesterday")
+	assert.Error(err)
+}
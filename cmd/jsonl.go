@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence"
+)
+
+// checkpointInterval is the number of imported events between checkpoints.
+const checkpointInterval = 1000
+
+func persistenceFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "driver",
+			Usage: "Overrides the persistence driver of the config",
+		},
+		&cli.StringFlag{
+			Name:  "dsn",
+			Usage: "Overrides the persistence DSN of the config",
+		},
+	}
+}
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Exports the events as JSONL, one event per line in ID order",
+		Flags: append(persistenceFlags(),
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Specifies the output file, or - for stdout",
+				Value:   "-",
+			},
+			&cli.TimestampFlag{
+				Name:   "since",
+				Usage:  "Exports the events after the given time",
+				Layout: time.RFC3339,
+			},
+			&cli.IntFlag{
+				Name:  "batch",
+				Usage: "Specifies the number of events fetched at once",
+				Value: 1000,
+			},
+			&cli.DurationFlag{
+				Name:  "idle",
+				Usage: "Stops once no event has been fetched for the given duration",
+				Value: 2 * time.Second,
+			},
+		),
+		Action: exportEvents,
+	}
+}
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Imports the events of a JSONL export, preserving their IDs",
+		Flags: append(persistenceFlags(),
+			&cli.StringFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "Specifies the input file, or - for stdin",
+				Value:   "-",
+			},
+			&cli.StringFlag{
+				Name:  "checkpoint",
+				Usage: "Specifies the checkpoint file; defaults to the input file with a .checkpoint suffix",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Skips the events imported before the last checkpoint",
+			},
+		),
+		Action: importEvents,
+	}
+}
+
+// line is the JSONL form of an event. The DataType of the payload is kept
+// along the event, since JSON alone does not tell JSON payloads from Any.
+type line struct {
+	*events.Event
+	Type events.DataType `json:"type"`
+}
+
+func newLine(e *events.Event) *line {
+	return &line{e, e.Payload.Type}
+}
+
+// restore gives the payload back its exported DataType.
+func (l *line) restore() error {
+	p := &l.Payload
+
+	switch {
+	case l.Type == events.JSON && p.Type == events.Any:
+		raw, err := json.Marshal(p.Data)
+		if err != nil {
+			return err
+		}
+
+		p.SetJSON(raw)
+
+	case l.Type == events.Any && p.Type == events.JSON:
+		raw, ok := p.JSON()
+		if !ok {
+			return events.ErrInvalidType
+		}
+
+		var data any
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return err
+		}
+
+		p.SetData(data)
+	}
+
+	return nil
+}
+
+// persistenceConfig returns the persistence of the config, overridden by the
+// --driver and --dsn flags. The config may be missing when both are set.
+func persistenceConfig(cli *cli.Context, driver string, dsn string) (events.Persistence, error) {
+	var p events.Persistence
+
+	cfg, err := loadConfig(cli)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || driver == "" || dsn == "" {
+			return p, err
+		}
+	} else {
+		p = cfg.Persistence
+	}
+
+	if driver != "" {
+		p.Driver = events.StorageDriver(driver)
+	}
+
+	if dsn != "" {
+		p.DSN = dsn
+	}
+
+	return p, nil
+}
+
+func exportEvents(cli *cli.Context) error {
+	cfg, err := persistenceConfig(cli, cli.String("driver"), cli.String("dsn"))
+	if err != nil {
+		return err
+	}
+
+	repo, err := persistence.NewEventRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	var w io.Writer = cli.App.Writer
+	if output := cli.String("output"); output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	var since time.Time
+	if ts := cli.Timestamp("since"); ts != nil {
+		since = *ts
+	}
+
+	n, err := iterate(cli.Context, repo, since, cli.Int("batch"), cli.Duration("idle"),
+		func(e *events.Event) error {
+			return enc.Encode(newLine(e))
+		})
+
+	if err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cli.App.ErrWriter, "%d events exported\n", n)
+	return nil
+}
+
+// iterate calls fn for every event since the given time, in ID order, until
+// no event has been fetched for the idle duration. It returns the number of
+// events.
+func iterate(ctx context.Context, repo events.Repository, since time.Time, batch int,
+	idle time.Duration, fn func(e *events.Event) error) (int, error) {
+
+	it, err := repo.Iterator(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close(nil)
+
+	return drain(ctx, it, batch, idle, fn)
+}
+
+// drain calls fn for every event fetched from the iterator, until no event
+// has been fetched for the idle duration or the context is done.
+func drain(ctx context.Context, it events.Iterator, batch int,
+	idle time.Duration, fn func(e *events.Event) error) (int, error) {
+
+	n := 0
+	last := time.Now()
+	for time.Since(last) < idle {
+		es, err := it.Fetch(batch)
+		if err != nil {
+			if !errors.Is(err, events.ErrEventEmpty) && !errors.Is(err, events.ErrTimeout) {
+				return n, err
+			}
+
+			select {
+			case <-ctx.Done():
+				return n, ctx.Err()
+
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			continue
+		}
+
+		for _, e := range es {
+			if err := fn(e); err != nil {
+				return n, err
+			}
+
+			n++
+		}
+
+		last = time.Now()
+	}
+
+	return n, nil
+}
+
+func importEvents(cli *cli.Context) error {
+	cfg, err := persistenceConfig(cli, cli.String("driver"), cli.String("dsn"))
+	if err != nil {
+		return err
+	}
+
+	input := cli.String("input")
+
+	checkpoint := cli.String("checkpoint")
+	if checkpoint == "" && input != "-" {
+		checkpoint = input + ".checkpoint"
+	}
+
+	var resumeFrom ulid.ULID
+	if cli.Bool("resume") {
+		if checkpoint == "" {
+			return errors.New("resuming from stdin requires a checkpoint file")
+		}
+
+		id, err := readCheckpoint(checkpoint)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		resumeFrom = id
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	repo, err := persistence.NewEventRepository(cfg)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var (
+		last              ulid.ULID
+		imported, skipped int
+	)
+
+	for {
+		l := &line{Event: new(events.Event)}
+		if err := dec.Decode(l); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return err
+		}
+
+		if l.ID.Compare(resumeFrom) <= 0 {
+			skipped++
+			continue
+		}
+
+		if err := l.restore(); err != nil {
+			return err
+		}
+
+		if err := repo.Store(l.Event); err != nil {
+			return err
+		}
+
+		last = l.ID
+		imported++
+
+		if checkpoint != "" && imported%checkpointInterval == 0 {
+			if err := commitCheckpoint(repo, checkpoint, last); err != nil {
+				return err
+			}
+		}
+	}
+
+	if checkpoint != "" && imported > 0 {
+		if err := commitCheckpoint(repo, checkpoint, last); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(cli.App.ErrWriter, "%d events imported, %d skipped\n", imported, skipped)
+	return nil
+}
+
+func readCheckpoint(name string) (ulid.ULID, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+
+	return ulid.ParseStrict(string(data))
+}
+
+// commitCheckpoint writes the checkpoint once the events imported before it
+// are written, since buffering drivers write them in batches after Store has
+// returned.
+func commitCheckpoint(repo events.Repository, name string, id ulid.ULID) error {
+	if flusher, ok := repo.(events.Flusher); ok {
+		if err := flusher.Flush(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	return writeCheckpoint(name, id)
+}
+
+// writeCheckpoint replaces the file atomically, so a crash never leaves it
+// empty.
+func writeCheckpoint(name string, id ulid.ULID) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id.String()), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence"
+)
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	file := filepath.Join(dir, "events.jsonl")
+
+	now := time.Now()
+	newID := func(d time.Duration) ulid.ULID {
+		id := ulid.Make()
+		id.SetTime(ulid.Timestamp(now.Add(-d)))
+		return id
+	}
+
+	dataset := []*events.Event{
+		events.NewEvent("hello.world", events.NewPayloadFromJSON([]byte(`{"msg":"Hello World"}`)), newID(4*time.Second)),
+		events.NewEvent("hello.world", events.NewPayload(map[string]any{"msg": "Hello"}), newID(3*time.Second)),
+		events.NewEvent("hello.world", events.NewPayload(3.14), newID(2*time.Second)),
+		events.NewEvent("hello.world", events.Payload{}, newID(time.Second)),
+	}
+
+	var p events.Payload
+	p.SetTypedBytes([]byte{0x08, 0x96, 0x01}, events.Protobuf, "type.googleapis.com/test.Message")
+	dataset = append(dataset, events.NewEvent("hello.proto", p))
+
+	repo, err := persistence.NewEventRepository(events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    src,
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	for _, e := range dataset {
+		repo.Store(e)
+	}
+
+	repo.Close()
+
+	var stderr bytes.Buffer
+	run := func(args ...string) error {
+		stderr.Reset()
+
+		app := newApp()
+		app.Writer = new(bytes.Buffer)
+		app.ErrWriter = &stderr
+
+		return app.Run(append([]string{"events", "--path", dir}, args...))
+	}
+
+	err = run("export", "--driver", "badger", "--dsn", src, "-o", file, "--idle", "1s")
+	if !assert.NoError(err) {
+		return
+	}
+
+	err = run("import", "--driver", "badger", "--dsn", dst, "-i", file)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// resuming skips every event already imported
+	err = run("import", "--driver", "badger", "--dsn", dst, "-i", file, "--resume")
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("0 events imported, 5 skipped\n", stderr.String())
+
+	repo, err = persistence.NewEventRepository(events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    dst,
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer repo.Close()
+
+	es := make([]*events.Event, 0)
+	_, err = iterate(context.Background(), repo, time.Time{}, 100, time.Second,
+		func(e *events.Event) error {
+			es = append(es, e)
+			return nil
+		})
+
+	if !assert.NoError(err) || !assert.Len(es, len(dataset)) {
+		return
+	}
+
+	for i, e := range dataset {
+		assert.Equal(e.ID, es[i].ID)
+		assert.Equal(e.Topic, es[i].Topic)
+		assert.Equal(e.Payload.Type, es[i].Payload.Type)
+		assert.Equal(e.Payload.TypeURL, es[i].Payload.TypeURL)
+
+		expected, _ := e.Payload.Raw()
+		actual, _ := es[i].Payload.Raw()
+		assert.Equal(expected, actual)
+	}
+}
+
+type flushRepository struct {
+	events.Repository
+	err error
+}
+
+func (repo *flushRepository) Flush(ctx context.Context) error {
+	return repo.err
+}
+
+func TestCommitCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "events.jsonl.checkpoint")
+	id := ulid.Make()
+
+	// the checkpoint stays behind the events not written
+	err := commitCheckpoint(&flushRepository{err: events.ErrBackpressure}, file, id)
+	assert.ErrorIs(err, events.ErrBackpressure)
+
+	_, err = readCheckpoint(file)
+	assert.ErrorIs(err, os.ErrNotExist)
+
+	assert.NoError(commitCheckpoint(&flushRepository{}, file, id))
+
+	checkpoint, err := readCheckpoint(file)
+	assert.NoError(err)
+	assert.Equal(id, checkpoint)
+}
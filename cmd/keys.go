@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/mirror520/events/persistence"
+	"github.com/mirror520/events/persistence/encryption"
+)
+
+func keysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "Manages the keys used to encrypt payloads at rest",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "rotate",
+				Usage:  "Adds a new master key and re-wraps all data keys with it; run it while the service is stopped",
+				Action: rotateKeys,
+			},
+		},
+	}
+}
+
+func rotateKeys(cli *cli.Context) error {
+	cfg, err := loadConfig(cli)
+	if err != nil {
+		return err
+	}
+
+	enc := cfg.Persistence.Encryption
+	if enc == nil {
+		return errors.New("encryption not configured")
+	}
+
+	keys, err := encryption.OpenKeyStore(enc.KeyFile, enc.KeyStore)
+	if err != nil {
+		return err
+	}
+
+	n, err := keys.Rotate()
+	if err != nil {
+		return err
+	}
+
+	// the key stores of the namespaces share the keyfile, so their data keys
+	// are re-wrapped with the new master key as well
+	for _, ns := range cfg.Namespaces {
+		keys, err := encryption.OpenKeyStore(enc.KeyFile, persistence.KeyStore(enc.KeyStore, ns.Name))
+		if err != nil {
+			return err
+		}
+
+		rewrapped, err := keys.Rewrap()
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", ns.Name, err)
+		}
+
+		n += rewrapped
+	}
+
+	fmt.Fprintf(cli.App.Writer, "%d data keys re-wrapped\n", n)
+	return nil
+}
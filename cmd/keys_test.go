@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events/persistence"
+	"github.com/mirror520/events/persistence/encryption"
+)
+
+func TestRotateKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	config := []byte(`
+persistence:
+  driver: inmem
+  encryption:
+    topics: [users/**]
+namespaces:
+  - name: acme
+`)
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), config, 0o600); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	keyFile := filepath.Join(dir, "master.key")
+	stores := []string{
+		persistence.KeyStore(filepath.Join(dir, "keys.json"), ""),
+		persistence.KeyStore(filepath.Join(dir, "keys.json"), "acme"),
+	}
+
+	dataKeys := make([][]byte, len(stores))
+	for i, store := range stores {
+		keys, err := encryption.OpenKeyStore(keyFile, store)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		_, dataKeys[i], err = keys.DataKey("users/alice")
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	var stdout bytes.Buffer
+
+	app := newApp()
+	app.Writer = &stdout
+	app.ErrWriter = new(bytes.Buffer)
+
+	if !assert.NoError(app.Run([]string{"events", "--path", dir, "keys", "rotate"})) {
+		return
+	}
+
+	assert.Contains(stdout.String(), "2 data keys re-wrapped")
+
+	// the old master key is retired, the data keys of every namespace remain
+	var masters encryption.MasterKeys
+	raw, _ := os.ReadFile(keyFile)
+	if err := json.Unmarshal(raw, &masters); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	current, err := masters.Key(masters.Current)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	masters.Keys = []*encryption.MasterKey{current}
+
+	raw, _ = json.Marshal(&masters)
+	if err := os.WriteFile(keyFile, raw, 0o600); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	for i, store := range stores {
+		keys, err := encryption.OpenKeyStore(keyFile, store)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		_, key, err := keys.DataKey("users/alice")
+		if assert.NoError(err, store) {
+			assert.Equal(dataKeys[i], key, store)
+		}
+	}
+}
@@ -1,24 +1,38 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 
 	"github.com/mirror520/events"
-	"github.com/mirror520/events/persistence"
+	"github.com/mirror520/events/auth"
+	"github.com/mirror520/events/replication"
 	"github.com/mirror520/events/transport/http"
 )
 
 func main() {
-	app := &cli.App{
+	app := newApp()
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newApp() *cli.App {
+	return &cli.App{
 		Name:  "events",
 		Usage: "The events is a versatile solution for storing, managing, and iterating through events.",
 		Flags: []cli.Flag{
@@ -34,25 +48,97 @@ func main() {
 				EnvVars: []string{"EVENTS_HTTP_PORT"},
 			},
 		},
+		Commands: []*cli.Command{
+			keysCommand(),
+			exportCommand(),
+			importCommand(),
+			migrateCommand(),
+			backupCommand(),
+			restoreCommand(),
+		},
 		Action: run,
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+func registerSchemas(svc events.Service, schemas []events.Schema) error {
+	for _, schema := range schemas {
+		bs, err := os.ReadFile(schema.File)
+		if err != nil {
+			return err
+		}
+
+		if err := svc.RegisterSchema(schema.Topic, bs); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func run(cli *cli.Context) error {
+// reloadConfig registers the schemas of the config again in every namespace,
+// the only part of the config applied without a restart, and audits the
+// reload in the audit trail of each namespace.
+func reloadConfig(cli *cli.Context, tenants []*tenant) {
+	log := zap.L().With(
+		zap.String("action", "reload_config"),
+	)
+
+	cfg, err := loadConfig(cli)
+
+	for _, t := range tenants {
+		record := &events.AuditRecord{
+			Action: events.ActionConfigReload,
+			Actor: events.Actor{
+				Principal: "system",
+			},
+		}
+
+		err := err
+		if err == nil {
+			err = registerSchemas(t.svc, cfg.Schemas)
+			record.Details = map[string]any{
+				"schemas": len(cfg.Schemas),
+			}
+		}
+
+		if err != nil {
+			record.Error = err.Error()
+			log.Error(err.Error(), zap.String("namespace", t.ns.Name))
+		} else {
+			log.Info("done", zap.String("namespace", t.ns.Name))
+		}
+
+		t.auditor.Audit(record)
+	}
+}
+
+func loadConfig(cli *cli.Context) (*events.Config, error) {
 	path := cli.String("path")
 	if path == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		path = homeDir + "/.events"
 	}
 
+	f, err := os.Open(path + "/config.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg *events.Config
+	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.SetPath(path)
+	return cfg, nil
+}
+
+func run(cli *cli.Context) error {
 	log, err := zap.NewDevelopment()
 	if err != nil {
 		return err
@@ -65,67 +151,272 @@ func run(cli *cli.Context) error {
 		zap.String("action", "main"),
 	)
 
-	f, err := os.Open(path + "/config.yaml")
+	cfg, err := loadConfig(cli)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	var cfg *events.Config
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		return err
+	// without tracing, the trace context of requests is still recorded
+	var tp trace.TracerProvider = noop.NewTracerProvider()
+	if cfg.Tracing != nil {
+		provider, shutdown, err := newTracerProvider(cfg.Tracing)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			shutdown(ctx)
+		}()
+
+		tp = provider
 	}
 
-	cfg.SetPath(path)
+	cfg.Persistence.Metrics = newDriverMetrics()
 
-	repo, err := persistence.NewEventRepository(cfg.Persistence)
+	tenants, err := newTenants(cfg, newMetrics(), tp)
 	if err != nil {
 		return err
 	}
 
-	svc := events.NewService(repo)
-	svc = events.LoggingMiddleware(zap.L())(svc)
-	svc.Up()
+	identity := endpoint.Middleware(func(next endpoint.Endpoint) endpoint.Endpoint {
+		return next
+	})
+
+	// authenticate returns the authentication of the routes of a namespace,
+	// with its ACL, or the ACL of auth, and admitting only its principals
+	authenticate := func(ns events.Namespace) endpoint.Middleware {
+		return identity
+	}
+
+	if cfg.Auth != nil {
+		authenticator, err := auth.NewAuthenticator(*cfg.Auth)
+		if err != nil {
+			return err
+		}
+
+		authenticate = func(ns events.Namespace) endpoint.Middleware {
+			acl := ns.ACL
+			if len(acl) == 0 {
+				acl = cfg.Auth.ACL
+			}
+
+			var policy *auth.Policy
+			if len(acl) > 0 {
+				policy = auth.NewPolicy(acl)
+			}
+
+			return endpoint.Chain(
+				auth.Middleware(authenticator, policy),
+				auth.NamespaceMiddleware(ns),
+			)
+		}
+	}
+
+	// the default namespace is served without a namespace, e.g. /v1/events
+	primary := tenants[0]
+
+	// replication covers the default namespace
+	readOnly := identity
+
+	var replicator *replication.Replicator
+	if repl := cfg.Replication; repl != nil {
+		client, err := http.NewClient(repl.Primary, nil, http.WithAPIKey(repl.APIKey))
+		if err != nil {
+			return err
+		}
+
+		replicator, err = replication.NewReplicator(client, primary.repo, *repl)
+		if err != nil {
+			return err
+		}
+
+		replicator.Up()
+		readOnly = replication.ReadOnlyMiddleware(replicator)
+	}
 
 	r := gin.Default()
+	r.ContextWithFallback = true // endpoints see the spans and actors of requests
+
+	// the address of clients owns their iterators without auth, so it is only
+	// taken from the X-Forwarded-For of trusted proxies
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+
+	// GET /metrics, registered before tracing, so scrapes are not traced
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// GET /healthz and /readyz, the probes of orchestrators, unauthenticated
+	{
+		svcs := make([]events.Service, len(tenants))
+		for i, t := range tenants {
+			svcs[i] = t.svc
+		}
+
+		r.GET("/healthz", http.HealthHandler())
+		r.GET("/readyz", http.ReadinessHandler(events.ReadinessEndpoint(svcs...)))
+	}
+
+	r.Use(http.TracingMiddleware(tp), http.ActorMiddleware(), http.AuthMiddleware())
+
 	apiV1 := r.Group("/v1")
 
+	registerRoutes(apiV1, primary, authenticate(primary.ns), readOnly)
+
+	// /v1/:namespace/events, and so on
+	for _, t := range tenants[1:] {
+		registerRoutes(apiV1.Group("/"+t.ns.Name), t, authenticate(t.ns), identity)
+	}
+
+	if replicator != nil {
+		authenticate := authenticate(primary.ns)
+
+		// GET /replication
+		{
+			endpoint := replication.StatusEndpoint(replicator)
+			endpoint = authenticate(endpoint)
+			apiV1.GET("/replication", http.ReplicationStatusHandler(endpoint))
+		}
+
+		// POST /replication/promote
+		{
+			endpoint := replication.PromoteEndpoint(replicator)
+			endpoint = events.AdminMiddleware()(endpoint)
+			endpoint = events.AuditMiddleware(primary.auditor, events.ActionReplicaPromote)(endpoint)
+			endpoint = authenticate(endpoint)
+			apiV1.POST("/replication/promote", http.PromoteReplicaHandler(endpoint))
+		}
+	}
+
+	go r.Run(":" + strconv.Itoa(cli.Int("port")))
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+wait:
+	for {
+		select {
+		case <-reload:
+			reloadConfig(cli, tenants)
+
+		case sign := <-quit:
+			log.Info(sign.String())
+			break wait
+		}
+	}
+
+	if replicator != nil {
+		replicator.Down()
+	}
+
+	for _, t := range tenants {
+		t.close()
+	}
+
+	log.Info("done")
+	return nil
+}
+
+// registerRoutes registers the routes of the events of a namespace, whose
+// iterators never see the events of another namespace.
+func registerRoutes(g *gin.RouterGroup, t *tenant, authenticate endpoint.Middleware, readOnly endpoint.Middleware) {
+	svc, auditor := t.svc, t.auditor
+
 	// PUT /events
 	{
 		endpoint := events.StoreEndpoint(svc)
+		endpoint = events.ValidationMiddleware(svc)(endpoint)
+		if t.limiter != nil {
+			endpoint = events.RateLimitMiddleware(t.limiter)(endpoint)
+		}
 		endpoint = events.MinifyMiddleware()(endpoint)
-		apiV1.PUT("/events", http.StoreHandler(endpoint))
+		endpoint = readOnly(endpoint)
+		endpoint = authenticate(endpoint)
+		g.PUT("/events", http.StoreHandler(endpoint))
 	}
 
 	// POST /events/iterators
 	{
 		endpoint := events.NewIteratorEndpoint(svc)
-		apiV1.POST("/events/iterators", http.NewIteratorHandler(endpoint))
+		endpoint = events.AuditMiddleware(auditor, events.ActionIteratorCreate)(endpoint)
+		endpoint = authenticate(endpoint)
+		g.POST("/events/iterators", http.NewIteratorHandler(endpoint))
+	}
+
+	// GET /events/iterators
+	{
+		endpoint := events.ListIteratorsEndpoint(svc)
+		endpoint = authenticate(endpoint)
+		g.GET("/events/iterators", http.ListIteratorsHandler(endpoint))
 	}
 
 	// GET /events/iterators/:id?batch=100
 	{
 		endpoint := events.FetchFromIterator(svc)
-		apiV1.GET("/events/iterators/:id", http.FetchFromIteratorHandler(endpoint))
+		endpoint = authenticate(endpoint)
+		g.GET("/events/iterators/:id", http.FetchFromIteratorHandler(endpoint))
 	}
 
 	// DELETE /events/iterators/:id
 	{
 		endpoint := events.CloseIterator(svc)
-		apiV1.DELETE("/events/iterators/:id", http.CloseIteratorHandler(endpoint))
+		endpoint = events.AuditMiddleware(auditor, events.ActionIteratorClose)(endpoint)
+		endpoint = authenticate(endpoint)
+		g.DELETE("/events/iterators/:id", http.CloseIteratorHandler(endpoint))
 	}
 
-	go r.Run(":" + strconv.Itoa(cli.Int("port")))
+	// DELETE /subjects/:subject
+	{
+		endpoint := events.ShredSubjectEndpoint(svc)
+		endpoint = events.AdminMiddleware()(endpoint)
+		endpoint = events.AuditMiddleware(auditor, events.ActionSubjectShred)(endpoint)
+		endpoint = authenticate(endpoint)
+		g.DELETE("/subjects/:subject", http.ShredSubjectHandler(endpoint))
+	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	admin := g.Group("/admin")
 
-	sign := <-quit
-	log.Info(sign.String())
+	// GET /admin/status
+	{
+		endpoint := events.StatusEndpoint(svc)
+		endpoint = events.AdminMiddleware()(endpoint)
+		endpoint = authenticate(endpoint)
+		admin.GET("/status", http.StatusHandler(endpoint))
+	}
 
-	svc.Down()
-	repo.Close()
+	// DELETE /admin/events/:id
+	{
+		endpoint := events.DeleteEventEndpoint(svc)
+		endpoint = events.AdminMiddleware()(endpoint)
+		endpoint = readOnly(endpoint)
+		endpoint = events.AuditMiddleware(auditor, events.ActionEventDelete)(endpoint)
+		endpoint = authenticate(endpoint)
+		admin.DELETE("/events/:id", http.DeleteEventHandler(endpoint))
+	}
 
-	log.Info("done")
-	return nil
+	// DELETE /admin/events?topic=hello.*&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z
+	{
+		endpoint := events.DeleteEventsEndpoint(svc)
+		endpoint = events.AdminMiddleware()(endpoint)
+		endpoint = readOnly(endpoint)
+		endpoint = events.AuditMiddleware(auditor, events.ActionEventsDelete)(endpoint)
+		endpoint = authenticate(endpoint)
+		admin.DELETE("/events", http.DeleteEventsHandler(endpoint))
+	}
+
+	// POST /admin/events/:id/redact
+	{
+		endpoint := events.RedactEventEndpoint(svc)
+		endpoint = events.AdminMiddleware()(endpoint)
+		endpoint = readOnly(endpoint)
+		endpoint = events.AuditMiddleware(auditor, events.ActionEventRedact)(endpoint)
+		endpoint = authenticate(endpoint)
+		admin.POST("/events/:id/redact", http.RedactEventHandler(endpoint))
+	}
 }
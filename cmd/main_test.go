@@ -75,7 +75,7 @@ func (suite *eventsTestSuite) TestStore() {
 		Type: events.JSON,
 	}
 
-	err := suite.svc.Store(topic, payload)
+	err := suite.svc.Store(events.NewEvent(topic, payload))
 	if err != nil {
 		suite.Fail(err.Error())
 		return
@@ -0,0 +1,76 @@
+package main
+
+import (
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mirror520/events"
+)
+
+const metricsNamespace = "events"
+
+// newMetrics registers the instruments of the service with the default
+// Prometheus registry.
+func newMetrics() *events.Metrics {
+	return &events.Metrics{
+		StoredEvents: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "service",
+			Name:      "stored_events_total",
+			Help:      "Number of events stored, by namespace, topic and whether storing failed.",
+		}, []string{"namespace", "topic", "error"}),
+		StoreLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "service",
+			Name:      "store_duration_seconds",
+			Help:      "Latency of storing an event, by namespace and topic.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"namespace", "topic"}),
+		FetchBatchSize: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "service",
+			Name:      "fetch_batch_size",
+			Help:      "Number of events returned by a fetch from an iterator, by namespace.",
+			Buckets:   stdprometheus.ExponentialBuckets(1, 4, 7),
+		}, []string{"namespace"}),
+		FetchLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "service",
+			Name:      "fetch_duration_seconds",
+			Help:      "Latency of a fetch from an iterator, by namespace and whether it failed.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"namespace", "error"}),
+		OpenIterators: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "service",
+			Name:      "open_iterators",
+			Help:      "Number of open iterators, by namespace.",
+		}, []string{"namespace"}),
+	}
+}
+
+// newDriverMetrics registers the instruments of the write buffers of the
+// drivers with the default Prometheus registry.
+func newDriverMetrics() *events.DriverMetrics {
+	return &events.DriverMetrics{
+		PendingEvents: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "persistence",
+			Name:      "pending_events",
+			Help:      "Number of buffered events not written yet, including spilled ones, by driver and namespace.",
+		}, []string{"driver", "namespace"}),
+		FlushDuration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "persistence",
+			Name:      "flush_duration_seconds",
+			Help:      "Duration of writing the buffered events, by driver and namespace.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"driver", "namespace"}),
+		FlushFailures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "persistence",
+			Name:      "flush_failures_total",
+			Help:      "Number of failed writes of buffered events, by driver and namespace.",
+		}, []string{"driver", "namespace"}),
+	}
+}
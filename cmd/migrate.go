@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence"
+)
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "migrate",
+		Usage:     "Copies the events from one driver to another, then tails the source until cut-over",
+		UsageText: "events migrate --from badger//var/lib/events/data --to 'mongo/mongodb://localhost:27017/?db=events'",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "from",
+				Usage:    "Specifies the source as <driver>/<dsn>",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "Specifies the target as <driver>/<dsn>",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "follow",
+				Usage: "Keeps copying new events of the source until interrupted",
+				Value: true,
+			},
+			&cli.IntFlag{
+				Name:  "batch",
+				Usage: "Specifies the number of events fetched at once",
+				Value: 1000,
+			},
+			&cli.DurationFlag{
+				Name:  "idle",
+				Usage: "Considers the source drained once no event has been fetched for the given duration",
+				Value: 2 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "window",
+				Usage: "Specifies the time window of the verification",
+				Value: time.Hour,
+			},
+		},
+		Action: migrateEvents,
+	}
+}
+
+// parseEndpoint splits a <driver>/<dsn> migration endpoint.
+func parseEndpoint(endpoint string) (string, string, error) {
+	driver, dsn, ok := strings.Cut(endpoint, "/")
+	if !ok || driver == "" || dsn == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q, expected <driver>/<dsn>", endpoint)
+	}
+
+	return driver, dsn, nil
+}
+
+func openEndpoint(cli *cli.Context, endpoint string) (events.Repository, error) {
+	driver, dsn, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := persistenceConfig(cli, driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return persistence.NewEventRepository(cfg)
+}
+
+func migrateEvents(cli *cli.Context) error {
+	source, err := openEndpoint(cli, cli.String("from"))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := openEndpoint(cli, cli.String("to"))
+	if err != nil {
+		return err
+	}
+
+	var (
+		out    = cli.App.ErrWriter
+		batch  = cli.Int("batch")
+		idle   = cli.Duration("idle")
+		copied = newChecksums(cli.Duration("window"))
+	)
+
+	copyFn := func(e *events.Event) error {
+		if err := target.Store(e); err != nil {
+			return err
+		}
+
+		copied.add(e)
+		return nil
+	}
+
+	// the iterator outlives the interruption, to drain the source at cut-over
+	it, err := source.Iterator(context.Background(), time.Time{})
+	if err != nil {
+		target.Close()
+		return err
+	}
+	defer it.Close(nil)
+
+	n, err := drain(cli.Context, it, batch, idle, copyFn)
+	if err != nil {
+		target.Close()
+		return err
+	}
+
+	fmt.Fprintf(out, "%d events copied\n", n)
+
+	if cli.Bool("follow") {
+		fmt.Fprintln(out, "tailing the source, interrupt to cut over")
+
+		ctx, stop := signal.NotifyContext(cli.Context, syscall.SIGINT, syscall.SIGTERM)
+		n, err := drain(ctx, it, batch, math.MaxInt64, copyFn)
+		stop()
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			target.Close()
+			return err
+		}
+
+		fmt.Fprintf(out, "%d events tailed\n", n)
+
+		// events stored before the writers switched over
+		n, err = drain(context.Background(), it, batch, idle, copyFn)
+		if err != nil {
+			target.Close()
+			return err
+		}
+
+		fmt.Fprintf(out, "%d events drained\n", n)
+	}
+
+	// closing flushes the events buffered by the target
+	if err := target.Close(); err != nil {
+		return err
+	}
+
+	target, err = openEndpoint(cli, cli.String("to"))
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	stored := newChecksums(copied.window)
+	if _, err := iterate(context.Background(), target, time.Time{}, batch, idle,
+		func(e *events.Event) error {
+			stored.add(e)
+			return nil
+		}); err != nil {
+		return err
+	}
+
+	diffs := copied.diff(stored)
+	for _, start := range diffs {
+		fmt.Fprintf(out, "window %s: %d events copied, %d events stored\n",
+			start.Format(time.RFC3339), copied.count(start), stored.count(start))
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("verification failed: %d windows differ", len(diffs))
+	}
+
+	fmt.Fprintf(out, "%d windows verified\n", len(copied.sums))
+	return nil
+}
+
+type checksum struct {
+	count int
+	sum   [sha256.Size]byte
+}
+
+// checksums accumulates the count and checksum of the events per time
+// window. The checksum of a window is the XOR of the hashes of its events,
+// so it does not depend on the order of events of the same millisecond.
+type checksums struct {
+	window time.Duration
+	sums   map[time.Time]*checksum
+}
+
+func newChecksums(window time.Duration) *checksums {
+	return &checksums{
+		window: window,
+		sums:   make(map[time.Time]*checksum),
+	}
+}
+
+func (cs *checksums) add(e *events.Event) {
+	start := e.Time().Truncate(cs.window)
+
+	c, ok := cs.sums[start]
+	if !ok {
+		c = new(checksum)
+		cs.sums[start] = c
+	}
+
+	raw, _ := e.Payload.Raw()
+
+	h := sha256.New()
+	h.Write(e.ID.Bytes())
+	h.Write([]byte(e.Topic))
+	h.Write([]byte{byte(e.Payload.Type)})
+	h.Write(raw)
+
+	for i, b := range h.Sum(nil) {
+		c.sum[i] ^= b
+	}
+
+	c.count++
+}
+
+func (cs *checksums) count(start time.Time) int {
+	if c, ok := cs.sums[start]; ok {
+		return c.count
+	}
+
+	return 0
+}
+
+// diff returns the start of the windows which differ, in time order.
+func (cs *checksums) diff(other *checksums) []time.Time {
+	diffs := make([]time.Time, 0)
+	for start, c := range cs.sums {
+		if o, ok := other.sums[start]; !ok || *o != *c {
+			diffs = append(diffs, start)
+		}
+	}
+
+	for start := range other.sums {
+		if _, ok := cs.sums[start]; !ok {
+			diffs = append(diffs, start)
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Before(diffs[j])
+	})
+
+	return diffs
+}
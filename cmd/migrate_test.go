@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence"
+)
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	repo, err := persistence.NewEventRepository(events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    src,
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		id := ulid.Make()
+		id.SetTime(ulid.Timestamp(now.Add(time.Duration(i-10) * time.Hour)))
+
+		repo.Store(events.NewEvent("hello.world", events.NewPayload(i), id))
+	}
+
+	repo.Close()
+
+	var stderr bytes.Buffer
+
+	app := newApp()
+	app.Writer = new(bytes.Buffer)
+	app.ErrWriter = &stderr
+
+	err = app.Run([]string{"events", "--path", dir, "migrate",
+		"--from", "badger/" + src,
+		"--to", "badger/" + dst,
+		"--follow=false",
+		"--idle", "1s",
+	})
+
+	assert.NoError(err)
+	assert.Contains(stderr.String(), "10 events copied")
+	assert.Contains(stderr.String(), "windows verified")
+}
+
+func TestChecksums(t *testing.T) {
+	assert := assert.New(t)
+
+	e1 := events.NewEvent("hello.world", events.NewPayload("Hello"))
+	e2 := events.NewEvent("hello.world", events.NewPayload("World"))
+
+	a := newChecksums(time.Hour)
+	a.add(e1)
+	a.add(e2)
+
+	// the order of events does not matter
+	b := newChecksums(time.Hour)
+	b.add(e2)
+	b.add(e1)
+
+	assert.Empty(a.diff(b))
+
+	c := newChecksums(time.Hour)
+	c.add(e1)
+
+	assert.Len(a.diff(c), 1)
+	assert.Len(c.diff(newChecksums(time.Hour)), 1)
+}
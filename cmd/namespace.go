@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence"
+	"github.com/mirror520/events/persistence/badger"
+)
+
+// tenant is the repository, service and audit trail of a namespace.
+type tenant struct {
+	ns      events.Namespace
+	repo    events.Repository
+	svc     events.Service
+	auditor events.Auditor
+	limiter *events.Limiter // nil without limits
+}
+
+// newTenant opens the repository of the namespace and starts its service,
+// with the schemas of the config.
+func newTenant(cfg *events.Config, ns events.Namespace, m *events.Metrics, tp trace.TracerProvider) (*tenant, error) {
+	persistenceCfg := cfg.Persistence
+	persistenceCfg.Namespace = ns.Name
+
+	repo, err := persistence.NewEventRepository(persistenceCfg)
+	if err != nil {
+		return nil, fmt.Errorf("namespace %q: %w", ns.Name, err)
+	}
+
+	m = m.Namespace(ns.Name)
+
+	svc := events.NewService(repo,
+		events.WithNamespace(ns.Name),
+		events.WithDriver(cfg.Persistence.Driver),
+		events.WithQuota(ns.Quota),
+		events.WithIdleTimeout(cfg.Iterators.IdleTimeout),
+		events.WithMaxIteratorsPerClient(cfg.Iterators.MaxPerClient),
+		events.WithRetention(cfg.Persistence.Retention...),
+		events.WithCompaction(cfg.Persistence.Compaction...),
+		events.WithOpenIterators(m.OpenIterators),
+	)
+	svc = events.LoggingMiddleware(zap.L().With(zap.String("namespace", ns.Name)))(svc)
+	svc = events.InstrumentingMiddleware(m)(svc)
+	svc = events.TracingMiddleware(tp)(svc)
+
+	if err := registerSchemas(svc, cfg.Schemas); err != nil {
+		repo.Close()
+		return nil, fmt.Errorf("namespace %q: %w", ns.Name, err)
+	}
+
+	svc.Up()
+
+	t := &tenant{
+		ns:      ns,
+		repo:    repo,
+		svc:     svc,
+		auditor: events.NewAuditor(repo),
+	}
+
+	limits := ns.Limits
+	if len(limits) == 0 {
+		limits = cfg.Limits
+	}
+
+	if len(limits) > 0 {
+		t.limiter = events.NewLimiter(limits...)
+	}
+
+	return t, nil
+}
+
+// newTenants starts the default namespace, followed by the namespaces of the
+// config.
+func newTenants(cfg *events.Config, m *events.Metrics, tp trace.TracerProvider) ([]*tenant, error) {
+	namespaces := []events.Namespace{
+		{Name: events.DefaultNamespace},
+	}
+
+	seen := make(map[string]struct{})
+	for _, ns := range cfg.Namespaces {
+		if err := events.ValidateNamespace(ns.Name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := seen[ns.Name]; ok {
+			return nil, fmt.Errorf("%w: %q is duplicated", events.ErrInvalidNamespace, ns.Name)
+		}
+
+		if len(ns.Principals) > 0 && cfg.Auth == nil {
+			return nil, fmt.Errorf("namespace %q: principals require auth", ns.Name)
+		}
+
+		seen[ns.Name] = struct{}{}
+		namespaces = append(namespaces, ns)
+	}
+
+	tenants := make([]*tenant, 0, len(namespaces))
+	for _, ns := range namespaces {
+		t, err := newTenant(cfg, ns, m, tp)
+		if err != nil {
+			for _, t := range tenants {
+				t.close()
+			}
+
+			return nil, err
+		}
+
+		tenants = append(tenants, t)
+	}
+
+	return tenants, nil
+}
+
+func (t *tenant) close() {
+	t.svc.Down()
+
+	if repo, ok := t.repo.(badger.EventRepository); ok {
+		stats := repo.CompressionStats()
+		zap.L().Info("compression stats",
+			zap.String("namespace", t.ns.Name),
+			zap.Uint64("events", stats.Events),
+			zap.Uint64("raw_bytes", stats.RawBytes),
+			zap.Uint64("compressed_bytes", stats.CompressedBytes),
+			zap.Float64("ratio", stats.Ratio),
+		)
+	}
+
+	t.repo.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/mirror520/events"
+)
+
+// newTracerProvider creates a tracer provider exporting the spans in batches.
+// The returned function flushes the pending spans and closes the file.
+func newTracerProvider(cfg *events.Tracing) (*sdktrace.TracerProvider, func(context.Context) error, error) {
+	var w io.WriteCloser = os.Stdout
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w = f
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName("events"),
+		)),
+	)
+
+	shutdown := func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+
+		if w != os.Stdout {
+			w.Close()
+		}
+
+		return err
+	}
+
+	return tp, shutdown, nil
+}
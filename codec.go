@@ -0,0 +1,229 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/oklog/ulid/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	ErrCodecNotFound = errors.New("codec not found")
+)
+
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var codecs sync.Map
+
+func init() {
+	RegisterCodec(&jsonCodec{})
+	RegisterCodec(&msgpackCodec{})
+	RegisterCodec(&cborCodec{})
+}
+
+// RegisterCodec makes a codec available by its name and content type,
+// replacing any codec previously registered under the same name.
+func RegisterCodec(c Codec) {
+	codecs.Store(c.Name(), c)
+}
+
+func CodecByName(name string) (Codec, error) {
+	val, ok := codecs.Load(name)
+	if !ok {
+		return nil, ErrCodecNotFound
+	}
+
+	c, ok := val.(Codec)
+	if !ok {
+		return nil, ErrInvalidType
+	}
+
+	return c, nil
+}
+
+func CodecByContentType(contentType string) (Codec, error) {
+	var codec Codec
+	codecs.Range(func(key, value any) bool {
+		c, ok := value.(Codec)
+		if ok && c.ContentType() == contentType {
+			codec = c
+			return false
+		}
+
+		return true
+	})
+
+	if codec == nil {
+		return nil, ErrCodecNotFound
+	}
+
+	return codec, nil
+}
+
+type jsonCodec struct{}
+
+func (c *jsonCodec) Name() string {
+	return "json"
+}
+
+func (c *jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (c *jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c *jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (c *msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (c *msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (c *msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (c *msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (c *cborCodec) Name() string {
+	return "cbor"
+}
+
+func (c *cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (c *cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (c *cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// Record is the codec-neutral form of an Event. Unlike the JSON form of an
+// Event, binary payloads are kept as raw bytes instead of base64 strings.
+type Record struct {
+	ID      []byte   `json:"id" msgpack:"id"`
+	Topic   string   `json:"topic" msgpack:"topic"`
+	Type    DataType `json:"type" msgpack:"type"`
+	TypeURL string   `json:"type_url,omitempty" msgpack:"type_url,omitempty"`
+	Data    []byte   `json:"data" msgpack:"data"`
+	Version int      `json:"version,omitempty" msgpack:"version,omitempty"`
+	KeyID   string   `json:"key_id,omitempty" msgpack:"key_id,omitempty"`
+	Subject string   `json:"subject,omitempty" msgpack:"subject,omitempty"`
+	Key     string   `json:"key,omitempty" msgpack:"key,omitempty"`
+
+	TraceParent string `json:"traceparent,omitempty" msgpack:"traceparent,omitempty"`
+}
+
+func NewRecord(e *Event) (*Record, error) {
+	data, err := e.Payload.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{
+		ID:      e.ID.Bytes(),
+		Topic:   e.Topic,
+		Type:    e.Payload.Type,
+		TypeURL: e.Payload.TypeURL,
+		Data:    data,
+		Version: e.Version,
+		KeyID:   e.KeyID,
+		Subject: e.Subject,
+		Key:     e.Key,
+
+		TraceParent: e.TraceParent,
+	}, nil
+}
+
+func (r *Record) Event() (*Event, error) {
+	var id ulid.ULID
+	if err := id.UnmarshalBinary(r.ID); err != nil {
+		return nil, err
+	}
+
+	payload, err := NewPayloadFromRaw(r.Type, r.Data, r.TypeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		ID:      id,
+		Topic:   r.Topic,
+		Payload: payload,
+		Version: r.Version,
+		KeyID:   r.KeyID,
+		Subject: r.Subject,
+		Key:     r.Key,
+
+		TraceParent: r.TraceParent,
+	}, nil
+}
+
+// Raw returns the payload data as bytes: JSON for Any and JSON payloads, and
+// the data itself for binary payloads.
+func (p *Payload) Raw() ([]byte, error) {
+	switch {
+	case p.Type == JSON:
+		raw, ok := p.JSON()
+		if !ok {
+			return nil, ErrInvalidType
+		}
+
+		return raw, nil
+
+	case p.Type.IsBinary():
+		bs, ok := p.Bytes()
+		if !ok {
+			return nil, ErrInvalidType
+		}
+
+		return bs, nil
+
+	default:
+		return json.Marshal(p.Data)
+	}
+}
+
+func NewPayloadFromRaw(t DataType, raw []byte, typeURL ...string) (p Payload, err error) {
+	switch {
+	case t == JSON:
+		p.SetJSON(raw)
+
+	case t.IsBinary():
+		err = p.SetTypedBytes(raw, t, typeURL...)
+
+	default:
+		var data any
+		if err = json.Unmarshal(raw, &data); err != nil {
+			return
+		}
+
+		p.SetData(data)
+	}
+
+	return
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCodecs(t *testing.T) {
+	assert := assert.New(t)
+
+	var pb Payload
+	pb.SetTypedBytes([]byte{0x08, 0x96, 0x01}, Protobuf, "type.googleapis.com/test.Message")
+
+	dataset := []*Event{
+		NewEvent("hello.world", NewPayloadFromJSON(json.RawMessage(`{"msg":"Hello World"}`))),
+		NewEvent("hello.world", NewPayload("Hello World")),
+		NewEvent("hello.world", NewPayload(3.14)),
+		NewEvent("hello.world", pb),
+	}
+
+	for _, name := range []string{"json", "msgpack", "cbor"} {
+		codec, err := CodecByName(name)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		for _, e := range dataset {
+			r, err := NewRecord(e)
+			if err != nil {
+				assert.Fail(err.Error())
+				return
+			}
+
+			data, err := codec.Marshal(r)
+			if err != nil {
+				assert.Fail(err.Error())
+				return
+			}
+
+			var decoded *Record
+			if err := codec.Unmarshal(data, &decoded); err != nil {
+				assert.Fail(err.Error())
+				return
+			}
+
+			actual, err := decoded.Event()
+			if err != nil {
+				assert.Fail(err.Error())
+				return
+			}
+
+			assert.Equal(e, actual, name)
+		}
+	}
+}
+
+func TestCodecByContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	codec, err := CodecByContentType("application/msgpack")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal("msgpack", codec.Name())
+
+	_, err = CodecByContentType("text/plain")
+	assert.ErrorIs(err, ErrCodecNotFound)
+}
+
+func TestTypedPayloadJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var payload Payload
+	payload.SetTypedBytes([]byte{0x08, 0x96, 0x01}, Protobuf, "type.googleapis.com/test.Message")
+
+	data, err := json.Marshal(&payload)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	var actual Payload
+	if err := json.Unmarshal(data, &actual); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(payload, actual)
+	assert.Equal("application/x-protobuf", actual.ContentType())
+}
@@ -1,8 +1,21 @@
 package events
 
+import (
+	"path/filepath"
+	"time"
+)
+
 type Config struct {
-	Persistence Persistence `yaml:"persistence"`
-	Path        string      `yaml:"-"`
+	Persistence    Persistence  `yaml:"persistence"`
+	Schemas        []Schema     `yaml:"schemas"`
+	Replication    *Replication `yaml:"replication"`
+	Tracing        *Tracing     `yaml:"tracing"`
+	Auth           *Auth        `yaml:"auth"`
+	Namespaces     []Namespace  `yaml:"namespaces"`
+	Limits         []Limit      `yaml:"limits"`
+	Iterators      Iterators    `yaml:"iterators"`
+	TrustedProxies []string     `yaml:"trusted_proxies"` // whose X-Forwarded-For is trusted
+	Path           string       `yaml:"-"`
 }
 
 func (cfg *Config) SetPath(path string) {
@@ -11,11 +24,231 @@ func (cfg *Config) SetPath(path string) {
 	if cfg.Persistence.Driver == BadgerDB && cfg.Persistence.DSN == "" {
 		cfg.Persistence.DSN = path + "/data"
 	}
+
+	// iterators expire unless disabled with a negative timeout
+	if cfg.Iterators.IdleTimeout == 0 {
+		cfg.Iterators.IdleTimeout = DefaultIdleTimeout
+	}
+
+	if buf := &cfg.Persistence.Buffer; buf.Overflow == OverflowSpill {
+		if buf.SpillDir == "" {
+			buf.SpillDir = "spill"
+		}
+
+		if !filepath.IsAbs(buf.SpillDir) {
+			buf.SpillDir = filepath.Join(path, buf.SpillDir)
+		}
+	}
+
+	if enc := cfg.Persistence.Encryption; enc != nil {
+		if enc.KeyFile == "" {
+			enc.KeyFile = "master.key"
+		}
+
+		if enc.KeyStore == "" {
+			enc.KeyStore = "keys.json"
+		}
+
+		if !filepath.IsAbs(enc.KeyFile) {
+			enc.KeyFile = filepath.Join(path, enc.KeyFile)
+		}
+
+		if !filepath.IsAbs(enc.KeyStore) {
+			enc.KeyStore = filepath.Join(path, enc.KeyStore)
+		}
+	}
+
+	if repl := cfg.Replication; repl != nil {
+		if repl.Position == "" {
+			repl.Position = "replication.pos"
+		}
+
+		if !filepath.IsAbs(repl.Position) {
+			repl.Position = filepath.Join(path, repl.Position)
+		}
+	}
+
+	if tracing := cfg.Tracing; tracing != nil {
+		if tracing.File != "" && !filepath.IsAbs(tracing.File) {
+			tracing.File = filepath.Join(path, tracing.File)
+		}
+	}
+
+	if auth := cfg.Auth; auth != nil && auth.JWT != nil {
+		if auth.JWT.JWKS != "" && !filepath.IsAbs(auth.JWT.JWKS) {
+			auth.JWT.JWKS = filepath.Join(path, auth.JWT.JWKS)
+		}
+	}
+
+	for i, schema := range cfg.Schemas {
+		if schema.File != "" && !filepath.IsAbs(schema.File) {
+			cfg.Schemas[i].File = filepath.Join(path, schema.File)
+		}
+	}
 }
 
 type Persistence struct {
-	Driver StorageDriver `yaml:"driver"`
-	DSN    string        `yaml:"dsn"`
+	Driver      StorageDriver       `yaml:"driver"`
+	DSN         string              `yaml:"dsn"`
+	Compression []CompressionPolicy `yaml:"compression"`
+	Encryption  *Encryption         `yaml:"encryption"`
+	Retention   []RetentionPolicy   `yaml:"retention"`
+	Compaction  []CompactionPolicy  `yaml:"compaction"`
+	Buffer      Buffer              `yaml:"buffer"`
+
+	Namespace string         `yaml:"-"` // isolates the events of a tenant
+	Metrics   *DriverMetrics `yaml:"-"`
+}
+
+// Buffer bounds the events buffered by the batch-writing drivers, MongoDB and
+// InfluxDB, until they are flushed.
+type Buffer struct {
+	Capacity  int            `yaml:"capacity"`   // events held in memory; defaults to 10000
+	FlushSize int            `yaml:"flush_size"` // flushes before the interval once reached; defaults to 1000
+	Overflow  OverflowPolicy `yaml:"overflow"`   // defaults to block
+	Timeout   time.Duration  `yaml:"timeout"`    // of blocking, before rejecting; defaults to 5s
+	SpillDir  string         `yaml:"spill_dir"`  // of spilling; defaults to spill in the path
+	MaxLag    int            `yaml:"max_lag"`    // events not written beyond which the driver is unhealthy; defaults to the capacity
+}
+
+// OverflowPolicy is the handling of the events stored into a full buffer.
+type OverflowPolicy string
+
+const (
+	OverflowBlock  OverflowPolicy = "block"  // waits for room, then rejects
+	OverflowReject OverflowPolicy = "reject" // rejects with ErrBackpressure
+	OverflowSpill  OverflowPolicy = "spill"  // appends to a file, read back once there is room
+)
+
+type CompressionPolicy struct {
+	Topic     string `yaml:"topic"`
+	Algorithm string `yaml:"algorithm"` // zstd or snappy
+	Threshold int    `yaml:"threshold"` // minimum payload size in bytes
+}
+
+type Schema struct {
+	Topic string `yaml:"topic"`
+	File  string `yaml:"file"`
+}
+
+type Encryption struct {
+	Topics   []string `yaml:"topics"`
+	KeyFile  string   `yaml:"keyfile"`
+	KeyStore string   `yaml:"keystore"`
+}
+
+// Replication makes the instance a read replica, which follows the events of
+// a primary instance through its HTTP API.
+type Replication struct {
+	Primary  string        `yaml:"primary"`  // base URL, e.g. http://primary:8080
+	Topic    string        `yaml:"topic"`    // topic of the iterator
+	Batch    int           `yaml:"batch"`    // events fetched at once
+	Interval time.Duration `yaml:"interval"` // polling interval once caught up
+	Position string        `yaml:"position"` // file of the replication position
+	APIKey   string        `yaml:"api_key"`  // API key of the primary, if required
+}
+
+// Tracing enables OpenTelemetry tracing; spans are exported as JSON lines.
+type Tracing struct {
+	File        string  `yaml:"file"`         // defaults to stdout
+	SampleRatio float64 `yaml:"sample_ratio"` // defaults to 1, i.e. every trace
+}
+
+// Auth requires the clients of the HTTP API to authenticate, with an API key
+// or a JWT bearer token.
+type Auth struct {
+	APIKeys []APIKey  `yaml:"api_keys"`
+	JWT     *JWT      `yaml:"jwt"`
+	ACL     []ACLRule `yaml:"acl"` // without rules, every topic is allowed
+}
+
+type APIKey struct {
+	Principal string `yaml:"principal"`
+	Key       string `yaml:"key"`
+}
+
+type JWT struct {
+	JWKS     string        `yaml:"jwks"`     // file of the JSON Web Key Set
+	Issuer   string        `yaml:"issuer"`   // expected issuer, if any
+	Audience string        `yaml:"audience"` // expected audience, if any
+	Claim    string        `yaml:"claim"`    // claim of the principal; defaults to sub
+	Leeway   time.Duration `yaml:"leeway"`   // clock skew tolerance; defaults to 1m
+}
+
+// ACLRule grants the principals the permission to publish to and subscribe
+// to the topic patterns, and to administer the store; the principal "*"
+// stands for any principal.
+type ACLRule struct {
+	Principals []string `yaml:"principals"`
+	Publish    []string `yaml:"publish"`
+	Subscribe  []string `yaml:"subscribe"`
+	Admin      bool     `yaml:"admin"` // delete, redact and shred events, read the status
+}
+
+func (rule ACLRule) Applies(principal string) bool {
+	for _, p := range rule.Principals {
+		if p == "*" || p == principal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Namespace isolates the events, iterators and audit trail of a tenant, which
+// is served under /v1/{namespace}.
+type Namespace struct {
+	Name       string    `yaml:"name"`
+	Principals []string  `yaml:"principals"` // principals admitted, "*" for any; requires auth
+	ACL        []ACLRule `yaml:"acl"`        // defaults to the ACL of auth
+	Limits     []Limit   `yaml:"limits"`     // defaults to the limits of the config
+	Quota      Quota     `yaml:"quota"`
+}
+
+// Admits reports whether the principal may access the namespace; without
+// principals, any authenticated principal may.
+func (ns Namespace) Admits(principal string) bool {
+	if len(ns.Principals) == 0 {
+		return true
+	}
+
+	return ACLRule{Principals: ns.Principals}.Applies(principal)
+}
+
+// Limit limits the events stored by a principal to the matching topics, with
+// a token bucket refilled at the rate, and with quotas per day in UTC. Each
+// principal has its own bucket and quotas, shared by the matching topics.
+type Limit struct {
+	Principals      []string `yaml:"principals"`         // "*" or none for any
+	Topic           string   `yaml:"topic"`              // pattern, none for any
+	Rate            float64  `yaml:"rate"`               // events per second
+	Burst           int      `yaml:"burst"`              // defaults to the rate
+	MaxEventsPerDay int64    `yaml:"max_events_per_day"` // events
+	MaxBytesPerDay  int64    `yaml:"max_bytes_per_day"`  // raw size of the payloads
+}
+
+func (l Limit) Applies(principal string, topic string) bool {
+	if len(l.Principals) > 0 && !(ACLRule{Principals: l.Principals}).Applies(principal) {
+		return false
+	}
+
+	return l.Topic == "" || l.Topic == "**" || MatchTopic(l.Topic, topic)
+}
+
+// DefaultIdleTimeout closes iterators abandoned by their clients.
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Iterators bounds the iterators of each namespace: iterators not fetched from
+// within the idle timeout are closed, and each client, i.e. principal, or
+// address without auth, may only open so many.
+type Iterators struct {
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`   // renewed by each fetch; negative never expires
+	MaxPerClient int           `yaml:"max_per_client"` // open iterators of a client
+}
+
+type Quota struct {
+	MaxIterators  int `yaml:"max_iterators"`   // open iterators
+	MaxEventBytes int `yaml:"max_event_bytes"` // raw size of a payload
 }
 
 type StorageDriver string
@@ -13,6 +13,12 @@ type StoreRequest struct {
 	ID      ulid.ULID `json:"id"`
 	Topic   string    `json:"topic"`
 	Payload Payload   `json:"payload"`
+	Subject string    `json:"subject"`
+	Key     string    `json:"key"`
+
+	// TraceParent is the W3C trace context of the producer; it defaults to
+	// the span of the request.
+	TraceParent string `json:"traceparent"`
 }
 
 func StoreEndpoint(svc Service) endpoint.Endpoint {
@@ -22,13 +28,30 @@ func StoreEndpoint(svc Service) endpoint.Endpoint {
 			return nil, errors.New("invalid request")
 		}
 
-		var err error
+		if IsReservedTopic(req.Topic) {
+			return nil, ErrReservedTopic
+		}
+
+		if perms, ok := PermissionsFromContext(ctx); ok && !perms.CanPublish(req.Topic) {
+			return nil, ErrForbidden
+		}
+
+		var e *Event
 		if req.ID.Time() == 0 {
-			err = svc.Store(req.Topic, req.Payload)
+			e = NewEvent(req.Topic, req.Payload)
 		} else {
-			err = svc.Store(req.Topic, req.Payload, req.ID)
+			e = NewEvent(req.Topic, req.Payload, req.ID)
 		}
 
+		e.Subject = req.Subject
+		e.Key = req.Key
+
+		e.TraceParent = req.TraceParent
+		if e.TraceParent == "" {
+			e.TraceParent = traceParent(ctx)
+		}
+
+		err := svc.Store(e)
 		return nil, err
 	}
 }
@@ -45,7 +68,21 @@ func NewIteratorEndpoint(svc Service) endpoint.Endpoint {
 			return nil, errors.New("invalid request")
 		}
 
-		return svc.NewIterator(req.Topic, req.Since)
+		if perms, ok := PermissionsFromContext(ctx); ok && !perms.CanSubscribe(req.Topic) {
+			return nil, ErrForbidden
+		}
+
+		owner := ActorFromContext(ctx).Client()
+		return svc.NewIterator(req.Topic, req.Since, WithOwner(owner))
+	}
+}
+
+// ListIteratorsEndpoint lists the open iterators of the client, see
+// Actor.Client.
+func ListIteratorsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		owner := ActorFromContext(ctx).Client()
+		return svc.Iterators(owner), nil
 	}
 }
 
@@ -72,7 +109,26 @@ func FetchFromIterator(svc Service) endpoint.Endpoint {
 			return nil, errors.New("invalid request")
 		}
 
-		return svc.FetchFromIterator(req.Batch, req.ID)
+		owner := ActorFromContext(ctx).Client()
+
+		es, err := svc.FetchFromIterator(req.Batch, req.ID, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		// events of topics the consumer may not read are skipped
+		if perms, ok := PermissionsFromContext(ctx); ok {
+			allowed := make([]*Event, 0, len(es))
+			for _, e := range es {
+				if perms.CanSubscribe(e.Topic) {
+					allowed = append(allowed, e)
+				}
+			}
+
+			es = allowed
+		}
+
+		return es, nil
 	}
 }
 
@@ -83,7 +139,105 @@ func CloseIterator(svc Service) endpoint.Endpoint {
 			return nil, errors.New("invalid request")
 		}
 
-		err := svc.CloseIterator(id)
+		owner := ActorFromContext(ctx).Client()
+
+		err := svc.CloseIterator(id, owner)
+		return nil, err
+	}
+}
+
+func ShredSubjectEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		subject, ok := request.(string)
+		if !ok {
+			return nil, errors.New("invalid request")
+		}
+
+		err := svc.ShredSubject(subject)
+		return nil, err
+	}
+}
+
+func DeleteEventEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		id, ok := request.(ulid.ULID)
+		if !ok {
+			return nil, errors.New("invalid request")
+		}
+
+		err := svc.DeleteEvent(id)
 		return nil, err
 	}
 }
+
+type DeleteEventsRequest struct {
+	Topic string    `form:"topic" binding:"required"`
+	From  time.Time `form:"from"`
+	To    time.Time `form:"to"`
+}
+
+func DeleteEventsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(DeleteEventsRequest)
+		if !ok {
+			return nil, errors.New("invalid request")
+		}
+
+		return svc.DeleteEvents(req.Topic, req.From, req.To)
+	}
+}
+
+type RedactEventRequest struct {
+	ID     ulid.ULID `json:"-"`
+	Reason string    `json:"reason"`
+}
+
+func RedactEventEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(RedactEventRequest)
+		if !ok {
+			return nil, errors.New("invalid request")
+		}
+
+		reason := req.Reason
+		if reason == "" {
+			reason = "redacted"
+		}
+
+		err := svc.RedactEvent(req.ID, reason)
+		return nil, err
+	}
+}
+
+// healthTimeout bounds the health checks of the repositories.
+const healthTimeout = 3 * time.Second
+
+func StatusEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+		defer cancel()
+
+		return svc.Status(ctx), nil
+	}
+}
+
+// ReadinessEndpoint reports the status of the services, e.g. of every
+// namespace, and fails with ErrUnhealthy when any of them is unhealthy.
+func ReadinessEndpoint(svcs ...Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+		defer cancel()
+
+		var err error
+
+		statuses := make([]*Status, len(svcs))
+		for i, svc := range svcs {
+			statuses[i] = svc.Status(ctx)
+			if !statuses[i].Healthy {
+				err = ErrUnhealthy
+			}
+		}
+
+		return statuses, err
+	}
+}
@@ -1,8 +1,11 @@
 package events
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,3 +29,193 @@ func TestUnmarshalStoreRequest(t *testing.T) {
 
 	assert.Equal("01HJJD04ZSE4T4SN6T7SVYBPNV", req.ID.String())
 }
+
+type topicPermissions struct {
+	publish   string
+	subscribe string
+	admin     bool
+}
+
+func (perms topicPermissions) CanPublish(topic string) bool {
+	return MatchTopic(perms.publish, topic)
+}
+
+func (perms topicPermissions) CanSubscribe(topic string) bool {
+	return topic == "" || MatchTopic(perms.subscribe, topic)
+}
+
+func (perms topicPermissions) CanAdminister() bool {
+	return perms.admin
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoint := AdminMiddleware()(func(ctx context.Context, request any) (any, error) {
+		return "deleted", nil
+	})
+
+	// a subscriber may not delete events
+	ctx := ContextWithPermissions(context.Background(), topicPermissions{
+		subscribe: "**",
+	})
+
+	_, err := endpoint(ctx, nil)
+	assert.ErrorIs(err, ErrForbidden)
+
+	ctx = ContextWithPermissions(context.Background(), topicPermissions{
+		admin: true,
+	})
+
+	response, err := endpoint(ctx, nil)
+	assert.NoError(err)
+	assert.Equal("deleted", response)
+
+	// without auth, there are no permissions to enforce
+	_, err = endpoint(context.Background(), nil)
+	assert.NoError(err)
+}
+
+func TestEndpointPermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := ContextWithPermissions(context.Background(), topicPermissions{
+		publish:   "sensors/*",
+		subscribe: "sensors/**",
+	})
+
+	next := &stubService{
+		es: []*Event{
+			NewEvent("sensors/a", NewPayload(21.5)),
+			NewEvent("logs/app", NewPayload("Hello World")),
+			NewEvent("sensors/a/b", NewPayload(22.0)),
+		},
+	}
+
+	store := StoreEndpoint(next)
+
+	_, err := store(ctx, StoreRequest{Topic: "sensors/a", Payload: NewPayload(21.5)})
+	assert.NoError(err)
+
+	_, err = store(ctx, StoreRequest{Topic: "logs/app", Payload: NewPayload("Hello World")})
+	assert.ErrorIs(err, ErrForbidden)
+
+	assert.Len(next.stored, 1)
+
+	newIterator := NewIteratorEndpoint(next)
+
+	_, err = newIterator(ctx, NewIteratorRequest{Topic: "logs/*"})
+	assert.ErrorIs(err, ErrForbidden)
+
+	fetch := FetchFromIterator(next)
+
+	response, err := fetch(ctx, FetchFromIteratorRequest{ID: "iterator", Batch: 100})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	es := response.([]*Event)
+	if assert.Len(es, 2) {
+		assert.Equal("sensors/a", es[0].Topic)
+		assert.Equal("sensors/a/b", es[1].Topic)
+	}
+
+	// without permissions, every topic is allowed
+	response, _ = fetch(context.Background(), FetchFromIteratorRequest{ID: "iterator", Batch: 100})
+	assert.Len(response, 3)
+}
+
+func TestIteratorEndpointsOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(new(iteratorRepository))
+	svc.Up()
+	defer svc.Down()
+
+	alice := ContextWithActor(context.Background(), Actor{Principal: "alice"})
+	bob := ContextWithActor(context.Background(), Actor{Principal: "bob"})
+
+	response, err := NewIteratorEndpoint(svc)(alice, NewIteratorRequest{Topic: "hello.*"})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	id := response.(string)
+
+	fetch := FetchFromIterator(svc)
+	closeIterator := CloseIterator(svc)
+
+	_, err = fetch(bob, FetchFromIteratorRequest{ID: id, Batch: 100})
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	_, err = closeIterator(bob, id)
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	// without auth, clients are told apart by their address
+	anonymous := ContextWithActor(context.Background(), Actor{RemoteAddr: "192.0.2.1"})
+
+	_, err = closeIterator(anonymous, id)
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	_, err = fetch(alice, FetchFromIteratorRequest{ID: id, Batch: 100})
+	assert.ErrorIs(err, ErrTimeout)
+
+	_, err = closeIterator(alice, id)
+	assert.NoError(err)
+}
+
+type healthRepository struct {
+	iteratorRepository
+	err      error
+	buffered int
+}
+
+func (repo *healthRepository) Health(ctx context.Context) error {
+	return repo.err
+}
+
+func (repo *healthRepository) Buffered() int {
+	return repo.buffered
+}
+
+func TestReadinessEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := &healthRepository{buffered: 3}
+
+	svc := NewService(repo, WithNamespace("acme"), WithDriver(MongoDB))
+	svc.Up()
+	defer svc.Down()
+
+	if _, err := svc.NewIterator("hello.*", time.Time{}); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	status := svc.Status(context.Background())
+	assert.Equal(&Status{
+		Namespace:     "acme",
+		Driver:        "mongo",
+		Healthy:       true,
+		OpenIterators: 1,
+		Buffered:      3,
+	}, status)
+
+	ready := ReadinessEndpoint(svc)
+
+	_, err := ready(context.Background(), nil)
+	assert.NoError(err)
+
+	repo.err = errors.New("server selection timeout")
+
+	response, err := ready(context.Background(), nil)
+	assert.ErrorIs(err, ErrUnhealthy)
+
+	statuses, ok := response.([]*Status)
+	if assert.True(ok) && assert.Len(statuses, 1) {
+		assert.False(statuses[0].Healthy)
+		assert.Equal("server selection timeout", statuses[0].Error)
+	}
+}
@@ -1,6 +1,7 @@
 package events
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -13,6 +14,12 @@ type Event struct {
 	ID      ulid.ULID `json:"id"`
 	Topic   string    `json:"topic"`
 	Payload Payload   `json:"payload"`
+	Version int       `json:"version,omitempty"`
+	KeyID   string    `json:"key_id,omitempty"`
+	Subject string    `json:"subject,omitempty"` // data subject, e.g. a user ID
+	Key     string    `json:"key,omitempty"`     // partition key, e.g. an entity ID
+
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context of the producer
 }
 
 func NewEvent(topic string, payload Payload, ids ...ulid.ULID) *Event {
@@ -34,17 +41,71 @@ func (e *Event) Time() time.Time {
 	return ulid.Time(e.ID.Time())
 }
 
+// IsTombstone reports whether the event marks the deletion of its key, i.e.
+// it has a key but no payload.
+func (e *Event) IsTombstone() bool {
+	return e.Key != "" && e.Payload.Type == Any && e.Payload.Data == nil
+}
+
 type DataType int
 
 const (
 	Any DataType = iota
 	JSON
 	Bytes
+
+	// content-typed binary payloads
+
+	Protobuf
+	MessagePack
+	CBOR
 )
 
+var contentTypes = map[DataType]string{
+	Any:         "application/json",
+	JSON:        "application/json",
+	Bytes:       "application/octet-stream",
+	Protobuf:    "application/x-protobuf",
+	MessagePack: "application/msgpack",
+	CBOR:        "application/cbor",
+}
+
+// DataTypeOf returns the binary DataType of the given media type, or Bytes
+// when the media type is unknown.
+func DataTypeOf(contentType string) DataType {
+	for t, ct := range contentTypes {
+		if t.IsBinary() && ct == contentType {
+			return t
+		}
+	}
+
+	switch contentType {
+	case "application/protobuf", "application/vnd.google.protobuf":
+		return Protobuf
+
+	case "application/x-msgpack", "application/vnd.msgpack":
+		return MessagePack
+	}
+
+	return Bytes
+}
+
+func (t DataType) ContentType() string {
+	return contentTypes[t]
+}
+
+// IsBinary reports whether the payload data is held as raw bytes.
+func (t DataType) IsBinary() bool {
+	return t >= Bytes && t <= CBOR
+}
+
 type Payload struct {
 	Data any
 	Type DataType
+
+	// TypeURL identifies the message type of Protobuf payloads,
+	// e.g. "type.googleapis.com/google.protobuf.Timestamp".
+	TypeURL string
 }
 
 func NewPayload(data any) Payload {
@@ -55,6 +116,33 @@ func NewPayloadFromJSON(data json.RawMessage) Payload {
 	return Payload{Data: data, Type: JSON}
 }
 
+// NewErasedPayload returns the marker that replaces the payload of an event
+// whose data has been erased, e.g. by crypto-shredding.
+func NewErasedPayload(reason string) Payload {
+	data, _ := json.Marshal(map[string]string{
+		"$erased": reason,
+	})
+
+	return NewPayloadFromJSON(data)
+}
+
+// IsErased reports whether the payload is the marker of erased data, see
+// NewErasedPayload.
+func (p *Payload) IsErased() bool {
+	raw, ok := p.JSON()
+	if !ok || !bytes.Contains(raw, []byte(`"$erased"`)) {
+		return false
+	}
+
+	var marker map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &marker); err != nil || len(marker) != 1 {
+		return false
+	}
+
+	_, ok = marker["$erased"]
+	return ok
+}
+
 func NewPayloadFromBytes(data []byte, raw ...bool) (p Payload, err error) {
 	if len(raw) > 0 && raw[0] {
 		p.SetBytes(data)
@@ -84,8 +172,25 @@ func (p *Payload) SetBytes(data []byte) {
 	p.Type = Bytes
 }
 
+// SetTypedBytes sets binary data of a content-typed DataType, such as
+// Protobuf with its type URL.
+func (p *Payload) SetTypedBytes(data []byte, t DataType, typeURL ...string) error {
+	if !t.IsBinary() {
+		return ErrInvalidType
+	}
+
+	p.Data = data
+	p.Type = t
+
+	if len(typeURL) > 0 {
+		p.TypeURL = typeURL[0]
+	}
+
+	return nil
+}
+
 func (p *Payload) Bytes() ([]byte, bool) {
-	if p.Type != Bytes {
+	if !p.Type.IsBinary() {
 		return nil, false
 	}
 
@@ -93,6 +198,10 @@ func (p *Payload) Bytes() ([]byte, bool) {
 	return bs, ok
 }
 
+func (p *Payload) ContentType() string {
+	return p.Type.ContentType()
+}
+
 func (p *Payload) SetData(data any) {
 	p.Data = data
 	p.Type = Any
@@ -117,7 +226,14 @@ func (p *Payload) UnmarshalJSON(data []byte) error {
 				return err
 			}
 
-			p.SetBytes(bs)
+			contentType, _ := val["$contentType"].(string)
+			if contentType == "" {
+				p.SetBytes(bs)
+				break
+			}
+
+			typeURL, _ := val["$typeUrl"].(string)
+			p.SetTypedBytes(bs, DataTypeOf(contentType), typeURL)
 		}
 
 	case []any:
@@ -156,6 +272,23 @@ func (p *Payload) MarshalJSON() ([]byte, error) {
 
 		return json.Marshal(binData)
 
+	case Protobuf, MessagePack, CBOR:
+		bs, ok := p.Bytes()
+		if !ok {
+			return nil, errors.New("invalid type")
+		}
+
+		binData := map[string]any{
+			"$binary":      bs,
+			"$contentType": p.ContentType(),
+		}
+
+		if p.TypeURL != "" {
+			binData["$typeUrl"] = p.TypeURL
+		}
+
+		return json.Marshal(binData)
+
 	default:
 		return nil, errors.New("invalid type")
 	}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/oklog/ulid/v2"
+)
+
+// Metrics are the instruments of the service, all labelled by namespace.
+type Metrics struct {
+	StoredEvents   metrics.Counter   // labels: topic, error
+	StoreLatency   metrics.Histogram // seconds; labels: topic
+	FetchBatchSize metrics.Histogram // events per fetch
+	FetchLatency   metrics.Histogram // seconds; labels: error
+	OpenIterators  metrics.Gauge     // passed to the service, see WithOpenIterators
+}
+
+// Namespace returns the instruments labelled with the namespace.
+func (m *Metrics) Namespace(name string) *Metrics {
+	return &Metrics{
+		StoredEvents:   m.StoredEvents.With("namespace", name),
+		StoreLatency:   m.StoreLatency.With("namespace", name),
+		FetchBatchSize: m.FetchBatchSize.With("namespace", name),
+		FetchLatency:   m.FetchLatency.With("namespace", name),
+		OpenIterators:  m.OpenIterators.With("namespace", name),
+	}
+}
+
+// DriverMetrics are the instruments of the write buffers of drivers, which
+// flush events in batches.
+type DriverMetrics struct {
+	PendingEvents metrics.Gauge     // labels: driver, namespace
+	FlushDuration metrics.Histogram // seconds; labels: driver, namespace
+	FlushFailures metrics.Counter   // labels: driver, namespace
+}
+
+// Driver returns the instruments labelled with the driver and the namespace,
+// or discarding instruments when the metrics are nil.
+func (m *DriverMetrics) Driver(driver string, namespace string) *DriverMetrics {
+	if m == nil {
+		return &DriverMetrics{
+			PendingEvents: discard.NewGauge(),
+			FlushDuration: discard.NewHistogram(),
+			FlushFailures: discard.NewCounter(),
+		}
+	}
+
+	return &DriverMetrics{
+		PendingEvents: m.PendingEvents.With("driver", driver, "namespace", namespace),
+		FlushDuration: m.FlushDuration.With("driver", driver, "namespace", namespace),
+		FlushFailures: m.FlushFailures.With("driver", driver, "namespace", namespace),
+	}
+}
+
+// InstrumentingMiddleware records the store rate and latency per topic, and
+// the size and latency of fetches. Open iterators are tracked by the service
+// itself, see WithOpenIterators.
+func InstrumentingMiddleware(m *Metrics) ServiceMiddleware {
+	return func(next Service) Service {
+		return &instrumentingMiddleware{m, next}
+	}
+}
+
+type instrumentingMiddleware struct {
+	metrics *Metrics
+	next    Service
+}
+
+func (mw *instrumentingMiddleware) Up() {
+	mw.next.Up()
+}
+
+func (mw *instrumentingMiddleware) Down() {
+	mw.next.Down()
+}
+
+func (mw *instrumentingMiddleware) Store(e *Event) (err error) {
+	defer func(begin time.Time) {
+		mw.metrics.StoredEvents.With("topic", e.Topic, "error", strconv.FormatBool(err != nil)).Add(1)
+		mw.metrics.StoreLatency.With("topic", e.Topic).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Store(e)
+}
+
+func (mw *instrumentingMiddleware) NewIterator(topic string, since time.Time, opts ...IteratorOption) (string, error) {
+	return mw.next.NewIterator(topic, since, opts...)
+}
+
+func (mw *instrumentingMiddleware) Iterator(id string) (Iterator, error) {
+	return mw.next.Iterator(id)
+}
+
+func (mw *instrumentingMiddleware) Iterators(owner string) []*IteratorInfo {
+	return mw.next.Iterators(owner)
+}
+
+func (mw *instrumentingMiddleware) FetchFromIterator(batch int, id string, owner string) (es []*Event, err error) {
+	defer func(begin time.Time) {
+		mw.metrics.FetchLatency.With("error", strconv.FormatBool(err != nil)).Observe(time.Since(begin).Seconds())
+		mw.metrics.FetchBatchSize.Observe(float64(len(es)))
+	}(time.Now())
+
+	return mw.next.FetchFromIterator(batch, id, owner)
+}
+
+func (mw *instrumentingMiddleware) CloseIterator(id string, owner string) error {
+	return mw.next.CloseIterator(id, owner)
+}
+
+func (mw *instrumentingMiddleware) RegisterSchema(topic string, schema []byte) error {
+	return mw.next.RegisterSchema(topic, schema)
+}
+
+func (mw *instrumentingMiddleware) ValidatePayload(topic string, payload Payload) error {
+	return mw.next.ValidatePayload(topic, payload)
+}
+
+func (mw *instrumentingMiddleware) RegisterUpcaster(topic string, version int, up Upcaster) error {
+	return mw.next.RegisterUpcaster(topic, version, up)
+}
+
+func (mw *instrumentingMiddleware) ShredSubject(subject string) error {
+	return mw.next.ShredSubject(subject)
+}
+
+func (mw *instrumentingMiddleware) DeleteEvent(id ulid.ULID) error {
+	return mw.next.DeleteEvent(id)
+}
+
+func (mw *instrumentingMiddleware) DeleteEvents(topic string, from time.Time, to time.Time) (int, error) {
+	return mw.next.DeleteEvents(topic, from, to)
+}
+
+func (mw *instrumentingMiddleware) RedactEvent(id ulid.ULID, reason string) error {
+	return mw.next.RedactEvent(id, reason)
+}
+
+func (mw *instrumentingMiddleware) Status(ctx context.Context) *Status {
+	return mw.next.Status(ctx)
+}
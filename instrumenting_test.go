@@ -0,0 +1,75 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/generic"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubService struct {
+	Service
+	err    error
+	es     []*Event
+	stored []*Event
+}
+
+func (svc *stubService) Store(e *Event) error {
+	if svc.err != nil {
+		return svc.err
+	}
+
+	svc.stored = append(svc.stored, e)
+	return nil
+}
+
+func (svc *stubService) FetchFromIterator(batch int, id string, owner string) ([]*Event, error) {
+	return svc.es, svc.err
+}
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	stored := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "stored"}, []string{"topic", "error"})
+	storeLatency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "store_latency"}, []string{"topic"})
+	batchSize := generic.NewSimpleHistogram()
+	fetchLatency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "fetch_latency"}, []string{"error"})
+
+	next := new(stubService)
+	svc := InstrumentingMiddleware(&Metrics{
+		StoredEvents:   kitprometheus.NewCounter(stored),
+		StoreLatency:   kitprometheus.NewHistogram(storeLatency),
+		FetchBatchSize: batchSize,
+		FetchLatency:   kitprometheus.NewHistogram(fetchLatency),
+	})(next)
+
+	svc.Store(NewEvent("hello.world", NewPayload("Hello World")))
+	svc.Store(NewEvent("hello.world", NewPayload("Hello World")))
+
+	next.err = errors.New("failed")
+	svc.Store(NewEvent("hello.world", NewPayload("Hello World")))
+
+	assert.Equal(2.0, testutil.ToFloat64(stored.WithLabelValues("hello.world", "false")))
+	assert.Equal(1.0, testutil.ToFloat64(stored.WithLabelValues("hello.world", "true")))
+	assert.Equal(1, testutil.CollectAndCount(storeLatency))
+
+	next.err = nil
+	next.es = []*Event{
+		NewEvent("hello.world", NewPayload("Hello World")),
+		NewEvent("hello.world", NewPayload("Hello World")),
+	}
+
+	es, err := svc.FetchFromIterator(100, "iterator", "")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Len(es, 2)
+	assert.Equal(2.0, batchSize.ApproximateMovingAverage())
+	assert.Equal(1, testutil.CollectAndCount(fetchLatency))
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// IteratorInfo describes an open iterator, as listed by Service.Iterators.
+type IteratorInfo struct {
+	ID           string     `json:"id"`
+	Topic        string     `json:"topic"`
+	Owner        string     `json:"owner"`
+	Position     string     `json:"position,omitempty"` // ID of the last fetched event
+	CreatedAt    time.Time  `json:"created_at"`
+	LastActivity time.Time  `json:"last_activity"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"` // none without idle timeout
+}
+
+// IteratorOption configures an iterator created by NewIterator.
+type IteratorOption func(*lease)
+
+// WithOwner sets the client owning the iterator, e.g. its principal, by
+// which the open iterators are limited and listed; anonymous by default.
+func WithOwner(client string) IteratorOption {
+	return func(l *lease) {
+		if client != "" {
+			l.owner = client
+		}
+	}
+}
+
+// lease is an open iterator of the service, renewed by each fetch and
+// expired once idle for the idle timeout.
+type lease struct {
+	Iterator
+	topic   string
+	owner   string
+	created time.Time
+
+	lastActivity time.Time
+	position     string
+	pending      []*Event // fetched, but not delivered
+	failed       ulid.ULID
+	failures     int // consecutive failed upcasts of the failed event
+	sync.Mutex
+}
+
+func newLease(topic string, opts ...IteratorOption) *lease {
+	now := time.Now()
+
+	l := &lease{
+		topic:        topic,
+		owner:        anonymous,
+		created:      now,
+		lastActivity: now,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// matches reports whether events of the topic are fetched by the iterator.
+func (l *lease) matches(topic string) bool {
+	if l.topic == "" {
+		return !IsReservedTopic(topic)
+	}
+
+	return MatchTopic(l.topic, topic)
+}
+
+// renew marks the iterator as active.
+func (l *lease) renew() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.lastActivity = time.Now()
+}
+
+// hold keeps the fetched events which could not be delivered, so the next
+// fetch delivers them again.
+func (l *lease) hold(es []*Event) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.pending = slices.Clone(es)
+}
+
+// fail records a failed upcast of the event, and returns how often it failed
+// in a row.
+func (l *lease) fail(id ulid.ULID) int {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.failed != id {
+		l.failed, l.failures = id, 0
+	}
+
+	l.failures++
+	return l.failures
+}
+
+// take returns and clears the events held back.
+func (l *lease) take() []*Event {
+	l.Lock()
+	defer l.Unlock()
+
+	es := l.pending
+	l.pending = nil
+
+	return es
+}
+
+// advance records the position of the fetched events.
+func (l *lease) advance(es []*Event) {
+	if len(es) == 0 {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.position = es[len(es)-1].ID.String()
+}
+
+// idle returns how long the iterator has not been fetched from.
+func (l *lease) idle(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	return now.Sub(l.lastActivity)
+}
+
+func (l *lease) info(idleTimeout time.Duration) *IteratorInfo {
+	l.Lock()
+	defer l.Unlock()
+
+	info := &IteratorInfo{
+		ID:           l.ID(),
+		Topic:        l.topic,
+		Owner:        l.owner,
+		Position:     l.position,
+		CreatedAt:    l.created,
+		LastActivity: l.lastActivity,
+	}
+
+	if idleTimeout > 0 {
+		expires := l.lastActivity.Add(idleTimeout)
+		info.ExpiresAt = &expires
+	}
+
+	return info
+}
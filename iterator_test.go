@@ -0,0 +1,264 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIteratorExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(new(iteratorRepository),
+		WithIdleTimeout(100*time.Millisecond),
+	)
+	svc.Up()
+	defer svc.Down()
+
+	id, err := svc.NewIterator("hello.*", time.Time{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// each fetch renews the lease
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+
+		_, err := svc.FetchFromIterator(10, id, "")
+		assert.ErrorIs(err, ErrTimeout)
+	}
+
+	infos := svc.Iterators("")
+	if assert.Len(infos, 1) {
+		assert.Equal(id, infos[0].ID)
+		assert.NotNil(infos[0].ExpiresAt)
+	}
+
+	assert.Eventually(func() bool {
+		_, err := svc.Iterator(id)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = svc.FetchFromIterator(10, id, "")
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	assert.Equal(0, svc.Status(context.Background()).OpenIterators)
+}
+
+func TestIteratorsPerClient(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(new(iteratorRepository),
+		WithMaxIteratorsPerClient(1),
+		WithIdleTimeout(-time.Second), // never expires
+	)
+	svc.Up()
+	defer svc.Down()
+
+	id, err := svc.NewIterator("hello.*", time.Time{}, WithOwner("alice"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = svc.NewIterator("hello.*", time.Time{}, WithOwner("alice"))
+	assert.ErrorIs(err, ErrQuotaExceeded)
+
+	// other clients have their own limit
+	_, err = svc.NewIterator("world.*", time.Time{}, WithOwner("bob"))
+	assert.NoError(err)
+
+	infos := svc.Iterators("alice")
+	if assert.Len(infos, 1) {
+		assert.Equal(id, infos[0].ID)
+		assert.Equal("hello.*", infos[0].Topic)
+		assert.Equal("alice", infos[0].Owner)
+		assert.Nil(infos[0].ExpiresAt)
+	}
+
+	assert.Len(svc.Iterators(""), 2)
+
+	// a closed iterator frees the slot of its client
+	assert.NoError(svc.CloseIterator(id, "alice"))
+	assert.Empty(svc.Iterators("alice"))
+
+	_, err = svc.NewIterator("hello.*", time.Time{}, WithOwner("alice"))
+	assert.NoError(err)
+}
+
+func TestIteratorOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(new(iteratorRepository))
+	svc.Up()
+	defer svc.Down()
+
+	id, err := svc.NewIterator("hello.*", time.Time{}, WithOwner("alice"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// other clients may neither fetch from nor close the iterator
+	_, err = svc.FetchFromIterator(10, id, "bob")
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	err = svc.CloseIterator(id, "bob")
+	assert.ErrorIs(err, ErrIteratorNotFound)
+
+	assert.Len(svc.Iterators("alice"), 1)
+
+	_, err = svc.FetchFromIterator(10, id, "alice")
+	assert.ErrorIs(err, ErrTimeout)
+
+	assert.NoError(svc.CloseIterator(id, "alice"))
+}
+
+// fetchIterator fetches its events once.
+type fetchIterator struct {
+	stubIterator
+	events []*Event
+}
+
+func (it *fetchIterator) Fetch(batch int) ([]*Event, error) {
+	if len(it.events) == 0 {
+		return nil, ErrTimeout
+	}
+
+	es := it.events
+	it.events = nil
+
+	return es, nil
+}
+
+type fetchRepository struct {
+	stubRepository
+	events []*Event
+}
+
+func (repo *fetchRepository) Iterator(ctx context.Context, since time.Time) (Iterator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &fetchIterator{stubIterator{ulid.Make().String(), ctx, cancel}, repo.events}, nil
+}
+
+func TestFetchFromIteratorUpcastFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	e := NewEvent("sensors", NewPayloadFromJSON(json.RawMessage(`{"temp":25}`)))
+
+	svc := NewService(&fetchRepository{events: []*Event{e}})
+	svc.Up()
+	defer svc.Down()
+
+	failing := true
+	svc.RegisterUpcaster("sensors", 0, func(payload Payload) (Payload, error) {
+		if failing {
+			return payload, errors.New("upcaster failed")
+		}
+
+		return payload, nil
+	})
+
+	id, err := svc.NewIterator("sensors", time.Time{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = svc.FetchFromIterator(10, id, "")
+	assert.Error(err)
+	assert.Empty(svc.Iterators("")[0].Position)
+
+	// the events are delivered once the upcaster succeeds
+	failing = false
+
+	es, err := svc.FetchFromIterator(10, id, "")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal(e.ID, es[0].ID)
+		assert.Equal(1, es[0].Version)
+	}
+
+	assert.Equal(e.ID.String(), svc.Iterators("")[0].Position)
+}
+
+func TestFetchFromIteratorUpcastSkipped(t *testing.T) {
+	assert := assert.New(t)
+
+	other := NewEvent("logs", NewPayload("Hello World"))
+	broken := NewEvent("sensors", NewPayloadFromJSON(json.RawMessage(`{"temp":25}`)))
+	e := NewEvent("sensors", NewPayloadFromJSON(json.RawMessage(`{"temp":26}`)))
+
+	svc := NewService(&fetchRepository{events: []*Event{other, broken, e}})
+	svc.Up()
+	defer svc.Down()
+
+	svc.RegisterUpcaster("sensors", 0, func(payload Payload) (Payload, error) {
+		if string(payload.Data.(json.RawMessage)) == `{"temp":25}` {
+			return payload, errors.New("upcaster failed")
+		}
+
+		return payload, nil
+	})
+
+	// events of other topics are not upcast
+	logs, err := svc.NewIterator("logs", time.Time{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	es, err := svc.FetchFromIterator(10, logs, "")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal(other.ID, es[0].ID)
+	}
+
+	sensors, err := svc.NewIterator("sensors", time.Time{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	for i := 1; i < maxUpcastAttempts; i++ {
+		_, err := svc.FetchFromIterator(10, sensors, "")
+		assert.Error(err)
+	}
+
+	// the event failing every attempt is skipped
+	es, err = svc.FetchFromIterator(10, sensors, "")
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal(e.ID, es[0].ID)
+	}
+}
+
+func TestFetchFromIteratorReservedTopics(t *testing.T) {
+	assert := assert.New(t)
+
+	e := NewEvent("hello.world", NewPayload("Hello World"))
+	record := NewEvent(AuditTopic, NewPayload("audited"))
+
+	svc := NewService(&fetchRepository{events: []*Event{e, record}})
+	svc.Up()
+	defer svc.Down()
+
+	for topic, expected := range map[string]*Event{
+		"**":       e,
+		AuditTopic: record,
+	} {
+		id, err := svc.NewIterator(topic, time.Time{})
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		es, err := svc.FetchFromIterator(10, id, "")
+		if assert.NoError(err) && assert.Len(es, 1, topic) {
+			assert.Equal(expected.ID, es[0].ID, topic)
+		}
+	}
+}
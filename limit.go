@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// LimitError rejects a request exceeding a limit, which may be retried after
+// the delay.
+type LimitError struct {
+	Err        error // ErrRateLimited or ErrQuotaExceeded
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Limiter enforces the limits of the events stored per principal. The topics
+// matching a limit share its bucket and quotas, so they are not escaped by
+// varying the topic.
+type Limiter struct {
+	limits []Limit
+	now    func() time.Time
+
+	buckets map[limitKey]*bucket
+	usage   map[limitKey]*usage
+	day     time.Time // the day of the usage, in UTC
+
+	sync.Mutex
+}
+
+type limitKey struct {
+	limit     int // index of the limit
+	principal string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type usage struct {
+	events int64
+	bytes  int64
+}
+
+func NewLimiter(limits ...Limit) *Limiter {
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: make(map[limitKey]*bucket),
+		usage:   make(map[limitKey]*usage),
+	}
+}
+
+func burst(l Limit) float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return math.Max(l.Rate, 1)
+}
+
+// Allow admits an event with a payload of the size, taking a token of each
+// bucket and counting the event in each quota, unless any limit rejects it.
+func (l *Limiter) Allow(principal string, topic string, size int) error {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.rollover(now)
+
+	keys := make([]limitKey, 0)
+	for i, limit := range l.limits {
+		if !limit.Applies(principal, topic) {
+			continue
+		}
+
+		key := limitKey{i, principal}
+
+		u := l.usage[key]
+		if u == nil {
+			u = new(usage)
+		}
+
+		if (limit.MaxEventsPerDay > 0 && u.events+1 > limit.MaxEventsPerDay) ||
+			(limit.MaxBytesPerDay > 0 && u.bytes+int64(size) > limit.MaxBytesPerDay) {
+			return &LimitError{ErrQuotaExceeded, l.day.AddDate(0, 0, 1).Sub(now)}
+		}
+
+		if limit.Rate > 0 {
+			b := l.refill(key, limit, now)
+			if b.tokens < 1 {
+				wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+				return &LimitError{ErrRateLimited, wait}
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	// every limit admits the event
+	for _, key := range keys {
+		if b, ok := l.buckets[key]; ok {
+			b.tokens--
+		}
+
+		u, ok := l.usage[key]
+		if !ok {
+			u = new(usage)
+			l.usage[key] = u
+		}
+
+		u.events++
+		u.bytes += int64(size)
+	}
+
+	return nil
+}
+
+// Cancel uncounts an admitted event which was not stored from the quotas; its
+// tokens are not returned.
+func (l *Limiter) Cancel(principal string, topic string, size int) {
+	l.Lock()
+	defer l.Unlock()
+
+	for i, limit := range l.limits {
+		if !limit.Applies(principal, topic) {
+			continue
+		}
+
+		if u, ok := l.usage[limitKey{i, principal}]; ok {
+			u.events = max(u.events-1, 0)
+			u.bytes = max(u.bytes-int64(size), 0)
+		}
+	}
+}
+
+func (l *Limiter) refill(key limitKey, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst(limit), last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(b.tokens+elapsed*limit.Rate, burst(limit))
+	b.last = now
+
+	return b
+}
+
+// rollover resets the quotas on a new day, and forgets the full buckets, so
+// idle principals are not kept.
+func (l *Limiter) rollover(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if day.Equal(l.day) {
+		return
+	}
+
+	l.day = day
+	clear(l.usage)
+
+	for key, b := range l.buckets {
+		limit := l.limits[key.limit]
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= burst(limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware rejects the events exceeding the limits of the principal
+// of the actor of the context, with a LimitError.
+func RateLimitMiddleware(limiter *Limiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			req, ok := request.(StoreRequest)
+			if !ok {
+				return nil, errors.New("invalid request")
+			}
+
+			size, err := payloadSize(req.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			principal := ActorFromContext(ctx).Principal
+			if err := limiter.Allow(principal, req.Topic, size); err != nil {
+				return nil, err
+			}
+
+			response, err := next(ctx, req)
+			if err != nil {
+				limiter.Cancel(principal, req.Topic, size)
+			}
+
+			return response, err
+		}
+	}
+}
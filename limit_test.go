@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC)
+
+	limiter := NewLimiter(
+		Limit{Principals: []string{"producer"}, Topic: "sensors/**", Rate: 1, Burst: 2},
+		Limit{Topic: "logs/*", MaxEventsPerDay: 2, MaxBytesPerDay: 100},
+	)
+	limiter.now = func() time.Time { return now }
+
+	// the burst, then a token per second
+	assert.NoError(limiter.Allow("producer", "sensors/a", 10))
+	assert.NoError(limiter.Allow("producer", "sensors/a", 10))
+
+	err := limiter.Allow("producer", "sensors/a", 10)
+
+	var lerr *LimitError
+	if assert.True(errors.As(err, &lerr)) {
+		assert.ErrorIs(err, ErrRateLimited)
+		assert.Equal(time.Second, lerr.RetryAfter)
+	}
+
+	// buckets are kept per principal, whatever the matching topic
+	assert.ErrorIs(limiter.Allow("producer", "sensors/b", 10), ErrRateLimited)
+	assert.NoError(limiter.Allow("consumer", "sensors/a", 10))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.ErrorIs(limiter.Allow("producer", "sensors/a", 10), ErrRateLimited)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.NoError(limiter.Allow("producer", "sensors/a", 10))
+
+	// quotas per day
+	assert.ErrorIs(limiter.Allow("producer", "logs/app", 101), ErrQuotaExceeded)
+	assert.NoError(limiter.Allow("producer", "logs/app", 50))
+
+	// an event not stored is not counted
+	limiter.Cancel("producer", "logs/app", 50)
+
+	assert.NoError(limiter.Allow("producer", "logs/app", 50))
+	assert.NoError(limiter.Allow("producer", "logs/app", 50))
+
+	err = limiter.Allow("producer", "logs/db", 0)
+	if assert.True(errors.As(err, &lerr)) {
+		assert.ErrorIs(err, ErrQuotaExceeded)
+		assert.Equal(59*time.Second, lerr.RetryAfter) // until midnight
+	}
+
+	now = now.Add(time.Minute)
+	assert.NoError(limiter.Allow("producer", "logs/app", 50))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter := NewLimiter(Limit{MaxEventsPerDay: 1})
+
+	stored := 0
+	endpoint := RateLimitMiddleware(limiter)(func(ctx context.Context, request any) (any, error) {
+		stored++
+		return nil, nil
+	})
+
+	ctx := ContextWithActor(context.Background(), Actor{Principal: "producer"})
+	req := StoreRequest{
+		Topic:   "hello.world",
+		Payload: NewPayloadFromJSON(json.RawMessage(`{"msg":"Hello World"}`)),
+	}
+
+	_, err := endpoint(ctx, req)
+	assert.NoError(err)
+
+	_, err = endpoint(ctx, req)
+	assert.ErrorIs(err, ErrQuotaExceeded)
+
+	// quotas are kept per principal
+	_, err = endpoint(context.Background(), req)
+	assert.NoError(err)
+
+	assert.Equal(2, stored)
+}
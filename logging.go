@@ -1,6 +1,7 @@
 package events
 
 import (
+	"context"
 	"time"
 
 	"github.com/oklog/ulid/v2"
@@ -29,13 +30,13 @@ func (mw *loggingMiddleware) Down() {
 	mw.next.Down()
 }
 
-func (mw *loggingMiddleware) Store(topic string, payload Payload, ids ...ulid.ULID) error {
+func (mw *loggingMiddleware) Store(e *Event) error {
 	log := mw.log.With(
 		zap.String("action", "store"),
-		zap.String("topic", topic),
+		zap.String("topic", e.Topic),
 	)
 
-	err := mw.next.Store(topic, payload)
+	err := mw.next.Store(e)
 	if err != nil {
 		log.Error(err.Error())
 		return err
@@ -45,14 +46,14 @@ func (mw *loggingMiddleware) Store(topic string, payload Payload, ids ...ulid.UL
 	return nil
 }
 
-func (mw *loggingMiddleware) NewIterator(topic string, since time.Time) (string, error) {
+func (mw *loggingMiddleware) NewIterator(topic string, since time.Time, opts ...IteratorOption) (string, error) {
 	log := mw.log.With(
 		zap.String("action", "new_iterator"),
 		zap.String("topic", topic),
 		zap.Time("since", since),
 	)
 
-	id, err := mw.next.NewIterator(topic, since)
+	id, err := mw.next.NewIterator(topic, since, opts...)
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
@@ -77,14 +78,18 @@ func (mw *loggingMiddleware) Iterator(id string) (Iterator, error) {
 	return it, nil
 }
 
-func (mw *loggingMiddleware) FetchFromIterator(batch int, id string) ([]*Event, error) {
+func (mw *loggingMiddleware) Iterators(owner string) []*IteratorInfo {
+	return mw.next.Iterators(owner)
+}
+
+func (mw *loggingMiddleware) FetchFromIterator(batch int, id string, owner string) ([]*Event, error) {
 	log := mw.log.With(
 		zap.String("action", "fetch"),
 		zap.String("iterator", id),
 		zap.Int("batch", batch),
 	)
 
-	events, err := mw.next.FetchFromIterator(batch, id)
+	events, err := mw.next.FetchFromIterator(batch, id, owner)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
@@ -94,13 +99,13 @@ func (mw *loggingMiddleware) FetchFromIterator(batch int, id string) ([]*Event,
 	return events, nil
 }
 
-func (mw *loggingMiddleware) CloseIterator(id string) error {
+func (mw *loggingMiddleware) CloseIterator(id string, owner string) error {
 	log := mw.log.With(
 		zap.String("action", "close_iterator"),
 		zap.String("iterator", id),
 	)
 
-	err := mw.next.CloseIterator(id)
+	err := mw.next.CloseIterator(id, owner)
 	if err != nil {
 		log.Error(err.Error())
 		return err
@@ -109,3 +114,118 @@ func (mw *loggingMiddleware) CloseIterator(id string) error {
 	log.Info("iterator closed")
 	return nil
 }
+
+func (mw *loggingMiddleware) RegisterSchema(topic string, schema []byte) error {
+	log := mw.log.With(
+		zap.String("action", "register_schema"),
+		zap.String("topic", topic),
+	)
+
+	err := mw.next.RegisterSchema(topic, schema)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("schema registered")
+	return nil
+}
+
+func (mw *loggingMiddleware) ValidatePayload(topic string, payload Payload) error {
+	return mw.next.ValidatePayload(topic, payload)
+}
+
+func (mw *loggingMiddleware) RegisterUpcaster(topic string, version int, up Upcaster) error {
+	log := mw.log.With(
+		zap.String("action", "register_upcaster"),
+		zap.String("topic", topic),
+		zap.Int("version", version),
+	)
+
+	err := mw.next.RegisterUpcaster(topic, version, up)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("upcaster registered")
+	return nil
+}
+
+func (mw *loggingMiddleware) ShredSubject(subject string) error {
+	log := mw.log.With(
+		zap.String("action", "shred_subject"),
+		zap.String("subject", subject),
+	)
+
+	err := mw.next.ShredSubject(subject)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("subject shredded")
+	return nil
+}
+
+func (mw *loggingMiddleware) DeleteEvent(id ulid.ULID) error {
+	log := mw.log.With(
+		zap.String("action", "delete_event"),
+		zap.String("id", id.String()),
+	)
+
+	err := mw.next.DeleteEvent(id)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("event deleted")
+	return nil
+}
+
+func (mw *loggingMiddleware) DeleteEvents(topic string, from time.Time, to time.Time) (int, error) {
+	log := mw.log.With(
+		zap.String("action", "delete_events"),
+		zap.String("topic", topic),
+		zap.Time("from", from),
+		zap.Time("to", to),
+	)
+
+	n, err := mw.next.DeleteEvents(topic, from, to)
+	if err != nil {
+		log.Error(err.Error())
+		return n, err
+	}
+
+	log.Info("events deleted", zap.Int("size", n))
+	return n, nil
+}
+
+func (mw *loggingMiddleware) RedactEvent(id ulid.ULID, reason string) error {
+	log := mw.log.With(
+		zap.String("action", "redact_event"),
+		zap.String("id", id.String()),
+		zap.String("reason", reason),
+	)
+
+	err := mw.next.RedactEvent(id, reason)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	log.Info("event redacted")
+	return nil
+}
+
+func (mw *loggingMiddleware) Status(ctx context.Context) *Status {
+	status := mw.next.Status(ctx)
+	if !status.Healthy {
+		mw.log.Warn(status.Error,
+			zap.String("action", "status"),
+		)
+	}
+
+	return status
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// DefaultNamespace is the namespace of the routes without a namespace, whose
+// events are stored as before namespaces existed.
+const DefaultNamespace = "default"
+
+var (
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrPayloadTooLarge  = errors.New("payload too large")
+)
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// reservedNamespaces are the names taken by the routes of the default
+// namespace, e.g. /v1/events.
+var reservedNamespaces = map[string]struct{}{
+	DefaultNamespace: {},
+	"events":         {},
+	"subjects":       {},
+	"admin":          {},
+	"replication":    {},
+}
+
+// ValidateNamespace checks that the name is usable by every driver, e.g. as
+// the suffix of a database name, and as the first segment of routes.
+func ValidateNamespace(name string) error {
+	if !namespacePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidNamespace, name)
+	}
+
+	if _, ok := reservedNamespaces[name]; ok {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidNamespace, name)
+	}
+
+	return nil
+}
+
+// IsDefaultNamespace reports whether the namespace is the default one, which
+// drivers store without isolation.
+func IsDefaultNamespace(name string) bool {
+	return name == "" || name == DefaultNamespace
+}
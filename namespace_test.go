@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateNamespace("acme"))
+	assert.NoError(ValidateNamespace("team-42"))
+
+	assert.ErrorIs(ValidateNamespace(""), ErrInvalidNamespace)
+	assert.ErrorIs(ValidateNamespace("Acme"), ErrInvalidNamespace)
+	assert.ErrorIs(ValidateNamespace("-acme"), ErrInvalidNamespace)
+	assert.ErrorIs(ValidateNamespace("acme/events"), ErrInvalidNamespace)
+
+	// the routes of the default namespace
+	assert.ErrorIs(ValidateNamespace("events"), ErrInvalidNamespace)
+	assert.ErrorIs(ValidateNamespace("admin"), ErrInvalidNamespace)
+	assert.ErrorIs(ValidateNamespace(DefaultNamespace), ErrInvalidNamespace)
+}
+
+type stubIterator struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (it *stubIterator) ID() string                        { return it.id }
+func (it *stubIterator) Fetch(batch int) ([]*Event, error) { return nil, ErrTimeout }
+func (it *stubIterator) Close(err error)                   { it.cancel() }
+func (it *stubIterator) Done() <-chan struct{}             { return it.ctx.Done() }
+func (it *stubIterator) Err() error                        { return it.ctx.Err() }
+
+type iteratorRepository struct {
+	stubRepository
+}
+
+func (repo *iteratorRepository) Iterator(ctx context.Context, since time.Time) (Iterator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &stubIterator{ulid.Make().String(), ctx, cancel}, nil
+}
+
+func TestServiceQuota(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(new(iteratorRepository),
+		WithNamespace("acme"),
+		WithQuota(Quota{
+			MaxIterators:  1,
+			MaxEventBytes: 16,
+		}),
+	)
+	svc.Up()
+	defer svc.Down()
+
+	err := svc.Store(NewEvent("hello.world", NewPayloadFromJSON(json.RawMessage(`{"msg":"hi"}`))))
+	assert.NoError(err)
+
+	err = svc.Store(NewEvent("hello.world", NewPayloadFromJSON(json.RawMessage(`{"msg":"Hello World"}`))))
+	assert.ErrorIs(err, ErrPayloadTooLarge)
+
+	id, err := svc.NewIterator("hello.*", time.Time{})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	_, err = svc.NewIterator("hello.*", time.Time{})
+	assert.ErrorIs(err, ErrQuotaExceeded)
+
+	// a closed iterator frees its slot
+	assert.NoError(svc.CloseIterator(id, ""))
+
+	_, err = svc.NewIterator("hello.*", time.Time{})
+	assert.NoError(err)
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+var (
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Permissions are the topics a principal may publish to and subscribe to,
+// and whether it may administer the store.
+type Permissions interface {
+	CanPublish(topic string) bool
+
+	// CanSubscribe reports whether events of the topic may be read; an empty
+	// topic asks whether any topic may be read.
+	CanSubscribe(topic string) bool
+
+	// CanAdminister reports whether events may be deleted, redacted and
+	// shredded, and the status of the store read.
+	CanAdminister() bool
+}
+
+type permissionsKey struct{}
+
+func ContextWithPermissions(ctx context.Context, perms Permissions) context.Context {
+	return context.WithValue(ctx, permissionsKey{}, perms)
+}
+
+// PermissionsFromContext returns the permissions of the principal of the
+// context; without permissions, every topic is allowed.
+func PermissionsFromContext(ctx context.Context) (Permissions, bool) {
+	perms, ok := ctx.Value(permissionsKey{}).(Permissions)
+	return perms, ok
+}
+
+// AdminMiddleware forbids the endpoint to principals without the admin
+// permission.
+func AdminMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			if perms, ok := PermissionsFromContext(ctx); ok && !perms.CanAdminister() {
+				return nil, ErrForbidden
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
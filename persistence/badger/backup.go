@@ -0,0 +1,77 @@
+package badger
+
+import (
+	"io"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxPendingWrites bounds the memory used while loading a backup.
+const maxPendingWrites = 256
+
+// Backup writes the entries with a version of at least since, including
+// deletions, of every namespace sharing the database, and returns the
+// version of the last written entry; the since of the next incremental
+// backup is that version plus one.
+func (repo *eventRepository) Backup(w io.Writer, since uint64) (uint64, error) {
+	stream := repo.db.NewStream()
+	stream.LogPrefix = "DB.Backup"
+
+	// the stream skips the versions up to SinceTs, while since is inclusive
+	if since > 0 {
+		stream.SinceTs = since - 1
+	}
+
+	return stream.Backup(w, since)
+}
+
+// Load restores the entries of a backup, of every namespace. Incremental
+// backups must be loaded in the order they were taken, after the full backup
+// they are based on.
+func (repo *eventRepository) Load(r io.Reader) error {
+	return repo.db.Load(r, maxPendingWrites)
+}
+
+// Truncate deletes every event after the given ID, of every namespace
+// sharing the database like Backup and Load, and returns their number.
+func (repo *eventRepository) Truncate(after ulid.ULID) (int, error) {
+	truncated := make([][]byte, 0)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			id, ok := eventID(it.Item().Key())
+			if !ok || id.Compare(after) <= 0 {
+				continue
+			}
+
+			truncated = append(truncated, it.Item().KeyCopy(nil))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range truncated {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(truncated), nil
+}
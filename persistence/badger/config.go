@@ -0,0 +1,30 @@
+package badger
+
+import (
+	"net/url"
+	"strings"
+)
+
+type Config struct {
+	Path  string
+	Codec string
+}
+
+func parseConfig(dsn string) (*Config, error) {
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	codec := "binary"
+	if q.Has("codec") {
+		codec = q.Get("codec")
+	}
+
+	return &Config{
+		Path:  path,
+		Codec: codec,
+	}, nil
+}
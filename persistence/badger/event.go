@@ -4,41 +4,492 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/oklog/ulid/v2"
 
 	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/compress"
 )
 
+type EventRepository interface {
+	events.Repository
+	CompressionStats() compress.Stats
+	Backup(w io.Writer, since uint64) (uint64, error)
+	Load(r io.Reader) error
+	Truncate(after ulid.ULID) (int, error)
+}
+
 type eventRepository struct {
-	db *badger.DB
+	db        *badger.DB
+	keys      keyspace
+	codec     events.Codec // nil for the compact binary record format
+	policies  *compress.Policies
+	retention []events.RetentionPolicy
+	sweeps    sync.Map // events.RetentionPolicy -> *sweep
+}
+
+// sweep holds the keys up to which a retention policy is enforced, so the next
+// sweep only scans the keys written since.
+type sweep struct {
+	cutoff   []byte // older events of the policy are expired
+	boundary []byte // oldest event kept by the max count
 }
 
 func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
-	opts := badger.DefaultOptions(cfg.DSN)
-	if cfg.DSN == "file::memory" {
+	conf, err := parseConfig(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := badger.DefaultOptions(conf.Path)
+	if conf.Path == "file::memory" {
 		opts = badger.DefaultOptions("").WithInMemory(true)
 	}
 
-	db, err := badger.Open(opts)
+	var codec events.Codec
+	if conf.Codec != "binary" {
+		codec, err = events.CodecByName(conf.Codec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policies, err := compress.NewPolicies(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec != nil && policies.Len() > 0 {
+		return nil, errors.New("compression requires the binary codec")
+	}
+
+	// namespaces stored in the same directory share the database
+	db, err := openDB(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &eventRepository{db}, nil
+	return &eventRepository{
+		db:        db,
+		keys:      newKeyspace(cfg.Namespace),
+		codec:     codec,
+		policies:  policies,
+		retention: cfg.Retention,
+	}, nil
+}
+
+func (repo *eventRepository) encode(e *events.Event) ([]byte, error) {
+	if repo.codec == nil {
+		return marshalRecord(e, repo.policies)
+	}
+
+	if repo.codec.Name() == "json" {
+		return json.Marshal(&e)
+	}
+
+	r, err := events.NewRecord(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.codec.Marshal(r)
+}
+
+// decode reads a value written in any format: binary records and JSON values
+// are recognized by their leading byte, so the codec can be changed on an
+// existing database.
+func (repo *eventRepository) decode(key []byte, val []byte) (*events.Event, error) {
+	if isRecord(val) {
+		id, err := repo.keys.id(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return unmarshalRecord(id, val)
+	}
+
+	if len(val) > 0 && val[0] == '{' {
+		var e *events.Event
+		if err := json.Unmarshal(val, &e); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	}
+
+	if repo.codec == nil {
+		return nil, ErrInvalidRecord
+	}
+
+	var r *events.Record
+	if err := repo.codec.Unmarshal(val, &r); err != nil {
+		return nil, err
+	}
+
+	return r.Event()
 }
 
 func (repo *eventRepository) Store(e *events.Event) error {
-	key := e.ID.Bytes()
-	val, err := json.Marshal(&e)
+	key := repo.keys.key(e.ID)
+	val, err := repo.encode(e)
 	if err != nil {
 		return err
 	}
 
+	entry := badger.NewEntry(key, val)
+	if ttl, ok := repo.ttl(e); ok {
+		entry = entry.WithTTL(ttl)
+	}
+
+	return repo.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
+
+// ttl returns the time to live of the event under the first retention policy
+// with a max age that matches its topic. Events stored past their max age,
+// e.g. by an import, expire at once, since sweeps do not return to older keys.
+func (repo *eventRepository) ttl(e *events.Event) (time.Duration, bool) {
+	for _, policy := range repo.retention {
+		if policy.MaxAge <= 0 || !policy.Match(e.Topic) {
+			continue
+		}
+
+		ttl := time.Until(e.Time().Add(policy.MaxAge))
+		return max(ttl, time.Nanosecond), true
+	}
+
+	return 0, false
+}
+
+// Retain deletes the events of the policy older than its max age, or beyond
+// its max count. Since keys are ordered by time, each sweep only scans the
+// keys since the last one, and only the topics of their records are read.
+func (repo *eventRepository) Retain(policy events.RetentionPolicy) (int, error) {
+	last := new(sweep)
+	if val, ok := repo.sweeps.Load(policy); ok {
+		last = val.(*sweep)
+	}
+
+	next := new(sweep)
+	if policy.MaxAge > 0 {
+		var id ulid.ULID
+		id.SetTime(ulid.Timestamp(time.Now().Add(-policy.MaxAge)))
+		next.cutoff = repo.keys.key(id)
+	}
+
+	expired := make([][]byte, 0)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		if next.cutoff != nil {
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			from := last.cutoff
+			if from == nil {
+				from = repo.keys.key(ulid.ULID{})
+			}
+
+			for it.Seek(from); it.Valid(); it.Next() {
+				item := it.Item()
+				if !repo.keys.contains(item.Key()) || bytes.Compare(item.Key(), next.cutoff) >= 0 {
+					break
+				}
+
+				topic, _, err := repo.peek(item)
+				if err != nil {
+					return err
+				}
+
+				if policy.Match(topic) {
+					expired = append(expired, item.KeyCopy(nil))
+				}
+			}
+		}
+
+		if policy.MaxCount > 0 {
+			opts.Reverse = true // newest first, to keep the latest max count events
+
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			count := 0
+			for it.Seek(repo.keys.last()); it.Valid(); it.Next() {
+				item := it.Item()
+				if !repo.keys.contains(item.Key()) {
+					break
+				}
+
+				// older events are expired, or beyond the count of the last sweep
+				if next.cutoff != nil && bytes.Compare(item.Key(), next.cutoff) < 0 {
+					break
+				}
+
+				if count >= policy.MaxCount && last.boundary != nil && bytes.Compare(item.Key(), last.boundary) < 0 {
+					break
+				}
+
+				topic, _, err := repo.peek(item)
+				if err != nil {
+					return err
+				}
+
+				if !policy.Match(topic) {
+					continue
+				}
+
+				if count >= policy.MaxCount {
+					expired = append(expired, item.KeyCopy(nil))
+					continue
+				}
+
+				count++
+				next.boundary = item.KeyCopy(nil)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range expired {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	repo.sweeps.Store(policy, next)
+
+	return len(expired), nil
+}
+
+// peek returns the topic and the key of the event of the item, reading only
+// the header of records.
+func (repo *eventRepository) peek(item *badger.Item) (topic string, key string, err error) {
+	err = item.Value(func(val []byte) error {
+		if isRecord(val) {
+			topic, key, err = peekRecord(val)
+			return err
+		}
+
+		e, err := repo.decode(item.Key(), val)
+		if err != nil {
+			return err
+		}
+
+		topic, key = e.Topic, e.Key
+		return nil
+	})
+
+	return topic, key, err
+}
+
+func (repo *eventRepository) Compact(policy events.CompactionPolicy) (int, error) {
+	var cutoff []byte
+	if policy.DeleteRetention > 0 {
+		var id ulid.ULID
+		id.SetTime(ulid.Timestamp(time.Now().Add(-policy.DeleteRetention)))
+		cutoff = repo.keys.key(id)
+	}
+
+	compacted := make([][]byte, 0)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true // newest first, to keep the latest event of each key
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		latest := make(map[string]struct{})
+		for it.Seek(repo.keys.last()); it.Valid() && repo.keys.contains(it.Item().Key()); it.Next() {
+			item := it.Item()
+
+			topic, key, err := repo.peek(item)
+			if err != nil {
+				return err
+			}
+
+			if key == "" || !policy.Match(topic) {
+				continue
+			}
+
+			topicKey := topic + "\x00" + key
+			if _, ok := latest[topicKey]; ok {
+				compacted = append(compacted, item.KeyCopy(nil))
+				continue
+			}
+
+			latest[topicKey] = struct{}{}
+
+			if cutoff == nil || bytes.Compare(item.Key(), cutoff) >= 0 {
+				continue
+			}
+
+			// only the payload of the latest events tells tombstones
+			var e *events.Event
+			err = item.Value(func(val []byte) error {
+				e, err = repo.decode(item.Key(), val)
+				return err
+			})
+
+			if err != nil {
+				return err
+			}
+
+			if e.IsTombstone() {
+				compacted = append(compacted, item.KeyCopy(nil))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range compacted {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(compacted), nil
+}
+
+func (repo *eventRepository) Delete(id ulid.ULID) error {
 	return repo.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(key, val)
+		key := repo.keys.key(id)
+		if _, err := txn.Get(key); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return events.ErrEventNotFound
+			}
+
+			return err
+		}
+
+		return txn.Delete(key)
+	})
+}
+
+func (repo *eventRepository) DeleteRange(topic string, from time.Time, to time.Time) (int, error) {
+	var lower, upper ulid.ULID
+	lower.SetTime(ulid.Timestamp(from))
+	upper.SetTime(ulid.Timestamp(to))
+
+	upperKey := repo.keys.key(upper)
+
+	deleted := make([][]byte, 0)
+	err := repo.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(repo.keys.key(lower)); it.Valid(); it.Next() {
+			item := it.Item()
+			if !repo.keys.contains(item.Key()) || bytes.Compare(item.Key(), upperKey) >= 0 {
+				break
+			}
+
+			var e *events.Event
+			err := item.Value(func(val []byte) error {
+				var err error
+				e, err = repo.decode(item.Key(), val)
+				return err
+			})
+
+			if err != nil {
+				return err
+			}
+
+			if events.MatchTopic(topic, e.Topic) {
+				deleted = append(deleted, item.KeyCopy(nil))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	wb := repo.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range deleted {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(deleted), nil
+}
+
+func (repo *eventRepository) Redact(id ulid.ULID, reason string) error {
+	return repo.db.Update(func(txn *badger.Txn) error {
+		key := repo.keys.key(id)
+
+		item, err := txn.Get(key)
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return events.ErrEventNotFound
+			}
+
+			return err
+		}
+
+		var e *events.Event
+		err = item.Value(func(val []byte) error {
+			e, err = repo.decode(key, val)
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		e.Payload = events.NewErasedPayload(reason)
+		e.KeyID = ""
+
+		val, err := repo.encode(e)
+		if err != nil {
+			return err
+		}
+
+		// the redacted event still expires with the original one
+		entry := badger.NewEntry(key, val)
+		entry.ExpiresAt = item.ExpiresAt()
+
+		return txn.SetEntry(entry)
 	})
 }
 
@@ -46,7 +497,7 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 	var (
 		prefetchSize = 10
 		ch           = make(chan *events.Event, prefetchSize*2) // buffer + prefetch
-		errCh        = make(chan error)
+		errCh        = make(chan error, 1)                      // never blocks the scan
 	)
 
 	ctx, cancel := context.WithCancelCause(ctx)
@@ -58,10 +509,11 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 		last.SetTime(ulid.Timestamp(since))
 
 		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-ctx.Done():
-				errCh <- nil
 				return
 
 			case <-ticker.C:
@@ -69,20 +521,30 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 					it := txn.NewIterator(opts)
 					defer it.Close()
 
-					for it.Seek(last.Bytes()); it.Valid(); it.Next() {
+					for it.Seek(repo.keys.key(last)); it.Valid(); it.Next() {
 						item := it.Item()
 
-						if bytes.Equal(item.Key(), last.Bytes()) {
+						// the scan never crosses into another namespace
+						if !repo.keys.contains(item.Key()) {
+							break
+						}
+
+						if bytes.Equal(item.Key(), repo.keys.key(last)) {
 							continue
 						}
 
 						err := item.Value(func(val []byte) error {
-							var e *events.Event
-							if err := json.Unmarshal(val, &e); err != nil {
+							e, err := repo.decode(item.Key(), val)
+							if err != nil {
 								return err
 							}
 
-							ch <- e
+							// a closed iterator is not fetched from anymore
+							select {
+							case ch <- e:
+							case <-ctx.Done():
+								return ctx.Err()
+							}
 
 							last = e.ID
 							return nil
@@ -97,7 +559,10 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 				})
 
 				if err != nil {
-					errCh <- err
+					if ctx.Err() == nil {
+						errCh <- err
+					}
+
 					return
 				}
 			}
@@ -118,8 +583,20 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 	return it, nil
 }
 
+func (repo *eventRepository) Health(ctx context.Context) error {
+	if repo.db.IsClosed() {
+		return badger.ErrDBClosed
+	}
+
+	return nil
+}
+
 func (repo *eventRepository) Close() error {
-	return repo.db.Close()
+	return closeDB(repo.db)
+}
+
+func (repo *eventRepository) CompressionStats() compress.Stats {
+	return repo.policies.Stats()
 }
 
 type iterator struct {
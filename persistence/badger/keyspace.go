@@ -0,0 +1,155 @@
+package badger
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mirror520/events"
+)
+
+// namespaceMarker leads the keys of named namespaces. Event keys of the
+// default namespace are bare ULIDs, whose first byte stays below the marker
+// for thousands of years, so they sort before every named namespace.
+const namespaceMarker = 0xFE
+
+// keyspace maps the IDs of the events of a namespace to keys: the default
+// namespace uses bare ULIDs, as before namespaces existed, and a named one
+// prefixes them with the marker and its name.
+type keyspace struct {
+	prefix []byte
+}
+
+func newKeyspace(namespace string) keyspace {
+	if events.IsDefaultNamespace(namespace) {
+		return keyspace{}
+	}
+
+	prefix := make([]byte, 0, len(namespace)+2)
+	prefix = append(prefix, namespaceMarker)
+	prefix = append(prefix, namespace...)
+	prefix = append(prefix, 0) // so no namespace is the prefix of another
+
+	return keyspace{prefix}
+}
+
+func (ks keyspace) key(id ulid.ULID) []byte {
+	if ks.prefix == nil {
+		return id.Bytes()
+	}
+
+	key := make([]byte, 0, len(ks.prefix)+len(id))
+	key = append(key, ks.prefix...)
+	return append(key, id[:]...)
+}
+
+// id returns the ULID of a key of the keyspace.
+func (ks keyspace) id(key []byte) (ulid.ULID, error) {
+	var id ulid.ULID
+	err := id.UnmarshalBinary(key[len(ks.prefix):])
+	return id, err
+}
+
+// contains reports whether the key belongs to the keyspace. Since keys of a
+// namespace are contiguous, scans stop at the first key not contained.
+func (ks keyspace) contains(key []byte) bool {
+	if ks.prefix == nil {
+		return len(key) == len(ulid.ULID{}) && key[0] != namespaceMarker
+	}
+
+	return len(key) == len(ks.prefix)+len(ulid.ULID{}) && bytes.HasPrefix(key, ks.prefix)
+}
+
+// eventID returns the ULID of an event key of any namespace, reporting
+// whether the key is one.
+func eventID(key []byte) (ulid.ULID, bool) {
+	if len(key) > 0 && key[0] == namespaceMarker {
+		end := bytes.IndexByte(key, 0)
+		if end < 0 {
+			return ulid.ULID{}, false
+		}
+
+		key = key[end+1:]
+	}
+
+	var id ulid.ULID
+	if len(key) != len(id) {
+		return id, false
+	}
+
+	copy(id[:], key)
+	return id, true
+}
+
+// last returns the key to seek by reverse iterators, which is at or after the
+// last key of the keyspace, and before the keys of other namespaces.
+func (ks keyspace) last() []byte {
+	if ks.prefix == nil {
+		return []byte{namespaceMarker}
+	}
+
+	key := bytes.Clone(ks.prefix)
+	return append(key, bytes.Repeat([]byte{0xFF}, len(ulid.ULID{}))...)
+}
+
+// databases shares the opened databases between the namespaces stored in the
+// same directory, since a directory is opened by one database at a time.
+var databases = struct {
+	open map[string]*sharedDB
+	sync.Mutex
+}{
+	open: make(map[string]*sharedDB),
+}
+
+type sharedDB struct {
+	db   *badger.DB
+	refs int
+}
+
+// openDB opens the database of the directory, or returns the one already
+// opened. In-memory databases are never shared.
+func openDB(opts badger.Options) (*badger.DB, error) {
+	if opts.InMemory {
+		return badger.Open(opts)
+	}
+
+	databases.Lock()
+	defer databases.Unlock()
+
+	if shared, ok := databases.open[opts.Dir]; ok {
+		shared.refs++
+		return shared.db, nil
+	}
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	databases.open[opts.Dir] = &sharedDB{db, 1}
+	return db, nil
+}
+
+// closeDB closes the database once no namespace uses it.
+func closeDB(db *badger.DB) error {
+	databases.Lock()
+	defer databases.Unlock()
+
+	for dir, shared := range databases.open {
+		if shared.db != db {
+			continue
+		}
+
+		shared.refs--
+		if shared.refs > 0 {
+			return nil
+		}
+
+		delete(databases.open, dir)
+		break
+	}
+
+	return db.Close()
+}
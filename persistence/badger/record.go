@@ -0,0 +1,259 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/compress"
+)
+
+// A record is the compact binary form of an event. The ULID is not part of
+// the record, since it already is the key:
+//
+//	magic    1 byte   0xEB
+//	version  1 byte   record format version
+//	type     1 byte   events.DataType of the payload
+//	flags    1 byte   compress.Algorithm of the payload
+//	topic    uvarint length + bytes
+//	attrs    (tag byte, uvarint length, bytes)..., terminated by a 0 tag
+//	payload  remaining bytes, raw data of the payload
+const (
+	recordMagic   byte = 0xEB
+	recordVersion byte = 1
+
+	recordHeaderSize = 4
+)
+
+// attribute tags
+const (
+	attrEnd byte = iota
+	attrVersion
+	attrTypeURL
+	attrKeyID
+	attrSubject
+	attrKey
+	attrTraceParent
+)
+
+var (
+	ErrInvalidRecord        = errors.New("invalid record")
+	ErrUnsupportedRecordVer = errors.New("unsupported record version")
+)
+
+func isRecord(val []byte) bool {
+	return len(val) >= recordHeaderSize && val[0] == recordMagic
+}
+
+// marshalRecord encodes the event as a record, compressing its payload when
+// one of the policies applies. The policies may be nil.
+func marshalRecord(e *events.Event, policies *compress.Policies) ([]byte, error) {
+	data, err := e.Payload.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	alg := compress.None
+	if policies != nil {
+		data, alg, err = policies.Compress(e.Topic, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, recordHeaderSize+len(e.Topic)+len(data)+8))
+	buf.Write([]byte{recordMagic, recordVersion, byte(e.Payload.Type), byte(alg)})
+
+	writeBytes(buf, []byte(e.Topic))
+
+	if e.Version > 0 {
+		buf.WriteByte(attrVersion)
+		writeBytes(buf, binary.AppendUvarint(nil, uint64(e.Version)))
+	}
+
+	if e.Payload.TypeURL != "" {
+		buf.WriteByte(attrTypeURL)
+		writeBytes(buf, []byte(e.Payload.TypeURL))
+	}
+
+	if e.KeyID != "" {
+		buf.WriteByte(attrKeyID)
+		writeBytes(buf, []byte(e.KeyID))
+	}
+
+	if e.Subject != "" {
+		buf.WriteByte(attrSubject)
+		writeBytes(buf, []byte(e.Subject))
+	}
+
+	if e.Key != "" {
+		buf.WriteByte(attrKey)
+		writeBytes(buf, []byte(e.Key))
+	}
+
+	if e.TraceParent != "" {
+		buf.WriteByte(attrTraceParent)
+		writeBytes(buf, []byte(e.TraceParent))
+	}
+
+	buf.WriteByte(attrEnd)
+	buf.Write(data)
+
+	return buf.Bytes(), nil
+}
+
+func unmarshalRecord(id ulid.ULID, val []byte) (*events.Event, error) {
+	if !isRecord(val) {
+		return nil, ErrInvalidRecord
+	}
+
+	if val[1] != recordVersion {
+		return nil, ErrUnsupportedRecordVer
+	}
+
+	dataType := events.DataType(val[2])
+	alg := compress.Algorithm(val[3])
+
+	r := bytes.NewReader(val[recordHeaderSize:])
+
+	topic, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &events.Event{
+		ID:    id,
+		Topic: string(topic),
+	}
+
+	var typeURL string
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if tag == attrEnd {
+			break
+		}
+
+		attr, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case attrVersion:
+			version, n := binary.Uvarint(attr)
+			if n <= 0 {
+				return nil, ErrInvalidRecord
+			}
+
+			e.Version = int(version)
+
+		case attrTypeURL:
+			typeURL = string(attr)
+
+		case attrKeyID:
+			e.KeyID = string(attr)
+
+		case attrSubject:
+			e.Subject = string(attr)
+
+		case attrKey:
+			e.Key = string(attr)
+
+		case attrTraceParent:
+			e.TraceParent = string(attr)
+
+		default:
+			// unknown attributes are skipped, for forward compatibility
+		}
+	}
+
+	data := make([]byte, r.Len())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	data, err = compress.Decompress(alg, data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := events.NewPayloadFromRaw(dataType, data, typeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Payload = payload
+	return e, nil
+}
+
+// peekRecord returns the topic and the key of the record, without reading its
+// payload.
+func peekRecord(val []byte) (topic string, key string, err error) {
+	if !isRecord(val) {
+		return "", "", ErrInvalidRecord
+	}
+
+	if val[1] != recordVersion {
+		return "", "", ErrUnsupportedRecordVer
+	}
+
+	r := bytes.NewReader(val[recordHeaderSize:])
+
+	bs, err := readBytes(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	topic = string(bs)
+
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return "", "", err
+		}
+
+		if tag == attrEnd {
+			return topic, key, nil
+		}
+
+		attr, err := readBytes(r)
+		if err != nil {
+			return "", "", err
+		}
+
+		if tag == attrKey {
+			key = string(attr)
+		}
+	}
+}
+
+func writeBytes(buf *bytes.Buffer, bs []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(bs))))
+	buf.Write(bs)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > uint64(r.Len()) {
+		return nil, ErrInvalidRecord
+	}
+
+	bs := make([]byte, size)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
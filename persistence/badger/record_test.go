@@ -0,0 +1,149 @@
+package badger
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/compress"
+)
+
+func TestRecord(t *testing.T) {
+	assert := assert.New(t)
+
+	var pb events.Payload
+	pb.SetTypedBytes([]byte{0x08, 0x96, 0x01}, events.Protobuf, "type.googleapis.com/test.Message")
+
+	bs, _ := events.NewPayloadFromBytes([]byte("Hello World"), true)
+
+	dataset := []*events.Event{
+		events.NewEvent("hello.world", events.NewPayloadFromJSON(json.RawMessage(`{"msg":"Hello World"}`))),
+		events.NewEvent("hello.world", events.NewPayload(nil)),
+		events.NewEvent("hello.world", events.NewPayload(true)),
+		events.NewEvent("hello.world", bs),
+		events.NewEvent("hello.world", pb),
+	}
+
+	dataset[0].Version = 3
+	dataset[3].KeyID = "dk-01HJJD04ZSE4T4SN6T7SVYBPNV"
+	dataset[4].TraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	dataset[4].Key = "sensor-1"
+
+	for _, e := range dataset {
+		val, err := marshalRecord(e, nil)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		actual, err := unmarshalRecord(e.ID, val)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(e, actual)
+
+		topic, key, err := peekRecord(val)
+		if assert.NoError(err) {
+			assert.Equal(e.Topic, topic)
+			assert.Equal(e.Key, key)
+		}
+	}
+
+	// compressed payload
+	{
+		policies, _ := compress.NewPolicies([]events.CompressionPolicy{
+			{Topic: "hello.*", Algorithm: "zstd"},
+		})
+
+		data := json.RawMessage(`[` + strings.Repeat(`"Hello World",`, 64) + `"Hello World"]`)
+		e := events.NewEvent("hello.world", events.NewPayloadFromJSON(data))
+
+		val, err := marshalRecord(e, policies)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(byte(compress.Zstd), val[3])
+		assert.Less(len(val), len(data))
+
+		actual, err := unmarshalRecord(e.ID, val)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(e, actual)
+	}
+
+	// binary payloads are stored as is, not as base64 strings
+	val, _ := marshalRecord(dataset[3], nil)
+	legacy, _ := json.Marshal(dataset[3])
+	assert.Less(len(val), len(legacy)/2)
+}
+
+func TestReadLegacyJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    "file::memory",
+	}
+
+	r, err := NewEventRepository(cfg)
+	if err != nil {
+		t.Skip(err.Error())
+		return
+	}
+	defer r.Close()
+
+	repo := r.(*eventRepository)
+
+	legacy := events.NewEvent("hello.world", events.NewPayload("Hello World"))
+	val, _ := json.Marshal(&legacy)
+
+	err = repo.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(legacy.ID.Bytes(), val)
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	e := events.NewEvent("hello.world", events.NewPayload("Hello Badger"))
+	if err := repo.Store(e); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	err = repo.db.View(func(txn *badger.Txn) error {
+		for _, expected := range []*events.Event{legacy, e} {
+			item, err := txn.Get(expected.ID.Bytes())
+			if err != nil {
+				return err
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			actual, err := repo.decode(item.Key(), val)
+			if err != nil {
+				return err
+			}
+
+			assert.Equal(expected, actual)
+		}
+
+		return nil
+	})
+
+	assert.NoError(err)
+}
@@ -0,0 +1,376 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/mirror520/events"
+)
+
+var (
+	ErrLagging = errors.New("buffer lagging")
+)
+
+const (
+	defaultCapacity  = 10000
+	defaultFlushSize = 1000
+	defaultTimeout   = 5 * time.Second
+)
+
+// Codec encodes the items spilled to disk.
+type Codec[T any] interface {
+	Marshal(item T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// Buffer is a bounded queue of the items of a batch-writing driver. Items are
+// removed once written, so a failed write is retried by the next drain.
+type Buffer[T any] struct {
+	cfg   events.Buffer
+	codec Codec[T]
+	depth metrics.Gauge
+
+	items []T
+	spill *spill        // nil unless the overflow policy is spill
+	room  chan struct{} // closed once items are removed
+	flush chan struct{} // signalled once the flush size is reached
+
+	writing sync.Mutex // held by Drain and Update
+	sync.Mutex
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error of a write which would fail again, e.g. of invalid
+// items, so Drain drops the items instead of retrying them.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// New returns a buffer of the config, reporting its depth to the gauge. The
+// name identifies the file of the spilled items, whose items left by a former
+// process are flushed again.
+func New[T any](cfg events.Buffer, name string, codec Codec[T], depth metrics.Gauge) (*Buffer[T], error) {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = defaultCapacity
+	}
+
+	if cfg.FlushSize <= 0 {
+		cfg.FlushSize = min(defaultFlushSize, cfg.Capacity)
+	}
+
+	if cfg.Overflow == "" {
+		cfg.Overflow = events.OverflowBlock
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	if cfg.MaxLag <= 0 {
+		cfg.MaxLag = cfg.Capacity
+	}
+
+	b := &Buffer[T]{
+		cfg:   cfg,
+		codec: codec,
+		depth: depth,
+		items: make([]T, 0),
+		room:  make(chan struct{}),
+		flush: make(chan struct{}, 1),
+	}
+
+	switch cfg.Overflow {
+	case events.OverflowBlock, events.OverflowReject:
+
+	case events.OverflowSpill:
+		if cfg.SpillDir == "" {
+			return nil, errors.New("spill requires a directory")
+		}
+
+		s, err := openSpill(filepath.Join(cfg.SpillDir, name+".spill"))
+		if err != nil {
+			return nil, err
+		}
+
+		b.spill = s
+
+	default:
+		return nil, errors.New("invalid overflow policy")
+	}
+
+	b.depth.Set(float64(b.len()))
+	return b, nil
+}
+
+// Push appends the item, unless the buffer is full: then it waits for room,
+// rejects the item with ErrBackpressure, or spills it, by the overflow policy.
+func (b *Buffer[T]) Push(item T) error {
+	var timeout <-chan time.Time
+
+	b.Lock()
+	for len(b.items) >= b.cfg.Capacity || b.spilled() > 0 {
+		switch b.cfg.Overflow {
+		case events.OverflowReject:
+			b.Unlock()
+			return events.ErrBackpressure
+
+		case events.OverflowSpill:
+			// once spilling, items are spilled until the spill is read back,
+			// so they are flushed in order
+			err := b.pushSpill(item)
+			b.Unlock()
+			return err
+		}
+
+		if timeout == nil {
+			timeout = time.After(b.cfg.Timeout)
+		}
+
+		room := b.room
+		b.Unlock()
+
+		select {
+		case <-room:
+		case <-timeout:
+			return events.ErrBackpressure
+		}
+
+		b.Lock()
+	}
+
+	b.items = append(b.items, item)
+	b.pushed()
+	b.Unlock()
+
+	return nil
+}
+
+func (b *Buffer[T]) pushSpill(item T) error {
+	data, err := b.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if err := b.spill.push(data); err != nil {
+		return err
+	}
+
+	b.pushed()
+	return nil
+}
+
+// pushed updates the depth, and signals a flush once the flush size is
+// reached.
+func (b *Buffer[T]) pushed() {
+	n := b.len()
+	b.depth.Set(float64(n))
+
+	if n >= b.cfg.FlushSize {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush is signalled once the buffer holds the flush size.
+func (b *Buffer[T]) Flush() <-chan struct{} {
+	return b.flush
+}
+
+// Drain writes the items in batches of the flush size with the function,
+// oldest first, until the buffer is empty or a write fails, and returns the
+// number of written items. Items of a failed write are kept, and retried by
+// the next drain, unless the error is permanent.
+func (b *Buffer[T]) Drain(write func(items []T) error) (int, error) {
+	b.writing.Lock()
+	defer b.writing.Unlock()
+
+	written := 0
+	for {
+		items, err := b.peek(b.cfg.FlushSize)
+		if err != nil || len(items) == 0 {
+			return written, err
+		}
+
+		if err := write(items); err != nil {
+			var perr *permanentError
+			if errors.As(err, &perr) {
+				b.remove(len(items))
+			}
+
+			return written, err
+		}
+
+		b.remove(len(items))
+		written += len(items)
+	}
+}
+
+// peek returns up to n of the oldest items. The spilled items are read back
+// first, as far as there is room.
+func (b *Buffer[T]) peek(n int) ([]T, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	for len(b.items) < b.cfg.Capacity && b.spilled() > 0 {
+		data, err := b.spill.pop()
+		if err != nil {
+			return nil, err
+		}
+
+		item, err := b.codec.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+
+		b.items = append(b.items, item)
+	}
+
+	if b.spill != nil && b.spill.n == 0 && b.spill.dirty {
+		if err := b.spill.reset(); err != nil {
+			return nil, err
+		}
+	}
+
+	n = min(n, len(b.items))
+
+	items := make([]T, n)
+	copy(items, b.items)
+
+	return items, nil
+}
+
+// remove removes the n oldest items, once written or dropped.
+func (b *Buffer[T]) remove(n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	n = min(n, len(b.items))
+	if n == 0 {
+		return
+	}
+
+	clear(b.items[:n])
+	b.items = b.items[n:]
+	b.depth.Set(float64(b.len()))
+
+	close(b.room)
+	b.room = make(chan struct{})
+}
+
+// Update replaces the items not written yet, including the spilled ones, with
+// the result of the function, e.g. to redact or delete them. It waits for
+// the items being written.
+func (b *Buffer[T]) Update(fn func(items []T) []T) error {
+	b.writing.Lock()
+	defer b.writing.Unlock()
+
+	b.Lock()
+	defer b.Unlock()
+
+	defer func() {
+		b.depth.Set(float64(b.len()))
+
+		close(b.room)
+		b.room = make(chan struct{})
+	}()
+
+	b.items = fn(b.items)
+
+	if b.spilled() == 0 {
+		return nil
+	}
+
+	spilled := make([]T, 0, b.spill.n)
+	for b.spill.n > 0 {
+		data, err := b.spill.pop()
+		if err != nil {
+			return err
+		}
+
+		item, err := b.codec.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+
+		spilled = append(spilled, item)
+	}
+
+	if err := b.spill.reset(); err != nil {
+		return err
+	}
+
+	for _, item := range fn(spilled) {
+		data, err := b.codec.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		if err := b.spill.push(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of items not written yet, including the spilled
+// ones.
+func (b *Buffer[T]) Len() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.len()
+}
+
+// Check fails with ErrLagging once the items not written yet reach the max
+// lag, e.g. since the backend is down.
+func (b *Buffer[T]) Check() error {
+	if n := b.Len(); n >= b.cfg.MaxLag {
+		return fmt.Errorf("%w: %d events not written", ErrLagging, n)
+	}
+
+	return nil
+}
+
+func (b *Buffer[T]) len() int {
+	return len(b.items) + b.spilled()
+}
+
+func (b *Buffer[T]) spilled() int {
+	if b.spill == nil {
+		return 0
+	}
+
+	return b.spill.n
+}
+
+// Close closes the spill file; the spilled items are kept for the next
+// process.
+func (b *Buffer[T]) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.spill == nil {
+		return nil
+	}
+
+	return b.spill.close()
+}
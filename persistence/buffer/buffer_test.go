@@ -0,0 +1,154 @@
+package buffer
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+type intCodec struct{}
+
+func (intCodec) Marshal(item int) ([]byte, error) {
+	return []byte(strconv.Itoa(item)), nil
+}
+
+func (intCodec) Unmarshal(data []byte) (int, error) {
+	return strconv.Atoi(string(data))
+}
+
+func drain(b *Buffer[int]) []int {
+	written := make([]int, 0)
+	b.Drain(func(items []int) error {
+		written = append(written, items...)
+		return nil
+	})
+
+	return written
+}
+
+func TestBufferReject(t *testing.T) {
+	assert := assert.New(t)
+
+	depth := generic.NewGauge("depth")
+
+	b, err := New[int](events.Buffer{
+		Capacity:  2,
+		FlushSize: 2,
+		Overflow:  events.OverflowReject,
+	}, "test", intCodec{}, depth)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NoError(b.Push(1))
+	assert.NoError(b.Push(2))
+	assert.ErrorIs(b.Push(3), events.ErrBackpressure)
+
+	assert.Equal(2.0, depth.Value())
+	assert.ErrorIs(b.Check(), ErrLagging)
+
+	// the flush size is reached
+	select {
+	case <-b.Flush():
+	default:
+		assert.Fail("flush not signalled")
+	}
+
+	// a failed write is retried, unless permanent
+	_, err = b.Drain(func(items []int) error {
+		return errors.New("unavailable")
+	})
+	assert.Error(err)
+	assert.Equal(2, b.Len())
+
+	_, err = b.Drain(func(items []int) error {
+		return Permanent(errors.New("invalid"))
+	})
+	assert.Error(err)
+	assert.Zero(b.Len())
+	assert.Zero(depth.Value())
+	assert.NoError(b.Check())
+}
+
+func TestBufferBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := New[int](events.Buffer{
+		Capacity: 1,
+		Timeout:  50 * time.Millisecond,
+	}, "test", intCodec{}, generic.NewGauge("depth"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.NoError(b.Push(1))
+	assert.ErrorIs(b.Push(2), events.ErrBackpressure)
+
+	// a blocked push resumes once the buffer is drained
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		drain(b)
+	}()
+
+	assert.NoError(b.Push(2))
+	assert.Equal([]int{2}, drain(b))
+}
+
+func TestBufferSpill(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := events.Buffer{
+		Capacity:  2,
+		FlushSize: 10,
+		Overflow:  events.OverflowSpill,
+		SpillDir:  t.TempDir(),
+	}
+
+	b, err := New[int](cfg, "test", intCodec{}, generic.NewGauge("depth"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(b.Push(i))
+	}
+
+	assert.Equal(5, b.Len())
+
+	// spilled items are updated too
+	err = b.Update(func(items []int) []int {
+		kept := make([]int, 0, len(items))
+		for _, item := range items {
+			if item != 4 {
+				kept = append(kept, item)
+			}
+		}
+
+		return kept
+	})
+	assert.NoError(err)
+
+	// the spilled items are kept for the next process
+	assert.NoError(b.Close())
+
+	b, err = New[int](cfg, "test", intCodec{}, generic.NewGauge("depth"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(2, b.Len())
+	assert.Equal([]int{3, 5}, drain(b))
+
+	assert.NoError(b.Push(6))
+	assert.Equal([]int{6}, drain(b))
+}
@@ -0,0 +1,149 @@
+package buffer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// spill is a file of length-prefixed frames, appended by one handle and read
+// back in order by another. Frames read back are held in memory, so they are
+// lost on a crash like any buffered item; frames left by a former process are
+// read back again from the start, since the file is only emptied once every
+// frame has been read back.
+type spill struct {
+	w     *os.File
+	r     *os.File
+	br    *bufio.Reader
+	n     int  // frames not read back
+	dirty bool // whether the file has frames, read back or not
+}
+
+func openSpill(path string) (*spill, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	s := &spill{
+		w:  w,
+		r:  r,
+		br: bufio.NewReader(r),
+	}
+
+	if err := s.recover(); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// recover counts the frames left by a former process, and cuts off a frame
+// partially written by a crash.
+func (s *spill) recover() error {
+	var offset int64
+	for {
+		data, err := readFrame(s.br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := s.w.Truncate(offset); err != nil {
+				return err
+			}
+
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		offset += int64(4 + len(data))
+		s.n++
+	}
+
+	if _, err := s.r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.br.Reset(s.r)
+	s.dirty = s.n > 0
+
+	return nil
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+func (s *spill) push(data []byte) error {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+
+	s.n++
+	s.dirty = true
+
+	return nil
+}
+
+func (s *spill) pop() ([]byte, error) {
+	data, err := readFrame(s.br)
+	if err != nil {
+		return nil, err
+	}
+
+	s.n--
+	return data, nil
+}
+
+// reset empties the file once every frame has been read back.
+func (s *spill) reset() error {
+	if err := s.w.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := s.r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.br.Reset(s.r)
+	s.n = 0
+	s.dirty = false
+
+	return nil
+}
+
+func (s *spill) close() error {
+	return errors.Join(s.w.Close(), s.r.Close())
+}
@@ -0,0 +1,195 @@
+package compress
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mirror520/events"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+)
+
+// Algorithm identifies a compression algorithm. The values are persisted by
+// drivers, so existing ones must never change.
+type Algorithm byte
+
+const (
+	None Algorithm = iota
+	Zstd
+	Snappy
+)
+
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "", "none":
+		return None, nil
+
+	case "zstd":
+		return Zstd, nil
+
+	case "snappy":
+		return Snappy, nil
+
+	default:
+		return None, ErrUnsupportedAlgorithm
+	}
+}
+
+func (alg Algorithm) String() string {
+	switch alg {
+	case None:
+		return "none"
+
+	case Zstd:
+		return "zstd"
+
+	case Snappy:
+		return "snappy"
+
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+)
+
+func Compress(alg Algorithm, data []byte) ([]byte, error) {
+	switch alg {
+	case None:
+		return data, nil
+
+	case Zstd:
+		return encoder.EncodeAll(data, make([]byte, 0, len(data))), nil
+
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func Decompress(alg Algorithm, data []byte) ([]byte, error) {
+	switch alg {
+	case None:
+		return data, nil
+
+	case Zstd:
+		return decoder.DecodeAll(data, nil)
+
+	case Snappy:
+		return snappy.Decode(nil, data)
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+type policy struct {
+	pattern   string
+	algorithm Algorithm
+	threshold int
+}
+
+// Policies selects the compression algorithm of a payload by its topic and
+// size, following the order of the configured policies.
+type Policies struct {
+	policies []policy
+	stats    stats
+}
+
+func NewPolicies(cfgs []events.CompressionPolicy) (*Policies, error) {
+	policies := make([]policy, len(cfgs))
+	for i, cfg := range cfgs {
+		alg, err := ParseAlgorithm(cfg.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		policies[i] = policy{
+			pattern:   cfg.Topic,
+			algorithm: alg,
+			threshold: cfg.Threshold,
+		}
+	}
+
+	return &Policies{policies: policies}, nil
+}
+
+func (p *Policies) Len() int {
+	return len(p.policies)
+}
+
+// Compress compresses the data according to the first policy matching the
+// topic. Data smaller than the threshold of the policy, or data that does not
+// shrink, is returned uncompressed with None.
+func (p *Policies) Compress(topic string, data []byte) ([]byte, Algorithm, error) {
+	for _, policy := range p.policies {
+		if !events.MatchTopic(policy.pattern, topic) {
+			continue
+		}
+
+		if policy.algorithm == None || len(data) < policy.threshold {
+			break
+		}
+
+		compressed, err := Compress(policy.algorithm, data)
+		if err != nil {
+			return nil, None, err
+		}
+
+		if len(compressed) >= len(data) {
+			break
+		}
+
+		p.stats.add(len(data), len(compressed))
+		return compressed, policy.algorithm, nil
+	}
+
+	return data, None, nil
+}
+
+func (p *Policies) Stats() Stats {
+	return p.stats.snapshot()
+}
+
+type Stats struct {
+	Events          uint64  `json:"events"`
+	RawBytes        uint64  `json:"raw_bytes"`
+	CompressedBytes uint64  `json:"compressed_bytes"`
+	Ratio           float64 `json:"ratio"`
+}
+
+type stats struct {
+	events          atomic.Uint64
+	rawBytes        atomic.Uint64
+	compressedBytes atomic.Uint64
+}
+
+func (s *stats) add(raw int, compressed int) {
+	s.events.Add(1)
+	s.rawBytes.Add(uint64(raw))
+	s.compressedBytes.Add(uint64(compressed))
+}
+
+func (s *stats) snapshot() Stats {
+	stats := Stats{
+		Events:          s.events.Load(),
+		RawBytes:        s.rawBytes.Load(),
+		CompressedBytes: s.compressedBytes.Load(),
+	}
+
+	if stats.CompressedBytes > 0 {
+		stats.Ratio = float64(stats.RawBytes) / float64(stats.CompressedBytes)
+	}
+
+	return stats
+}
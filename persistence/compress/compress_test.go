@@ -0,0 +1,90 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func TestPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	policies, err := NewPolicies([]events.CompressionPolicy{
+		{Topic: "sensors/*", Algorithm: "zstd", Threshold: 128},
+		{Topic: "logs/**", Algorithm: "snappy"},
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	data := bytes.Repeat([]byte(`{"temperature":25.3,"humidity":60}`), 32)
+
+	// zstd
+	{
+		compressed, alg, err := policies.Compress("sensors/1", data)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(Zstd, alg)
+		assert.Less(len(compressed), len(data))
+
+		decompressed, err := Decompress(alg, compressed)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(data, decompressed)
+	}
+
+	// snappy
+	{
+		compressed, alg, err := policies.Compress("logs/app/1", data)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(Snappy, alg)
+
+		decompressed, err := Decompress(alg, compressed)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(data, decompressed)
+	}
+
+	// below threshold
+	{
+		small := []byte(`{"temperature":25.3}`)
+		actual, alg, _ := policies.Compress("sensors/1", small)
+		assert.Equal(None, alg)
+		assert.Equal(small, actual)
+	}
+
+	// no policy
+	{
+		_, alg, _ := policies.Compress("hello/world", data)
+		assert.Equal(None, alg)
+	}
+
+	stats := policies.Stats()
+	assert.Equal(uint64(2), stats.Events)
+	assert.Equal(uint64(2*len(data)), stats.RawBytes)
+	assert.Greater(stats.Ratio, 1.0)
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseAlgorithm("lz4")
+	assert.ErrorIs(err, ErrUnsupportedAlgorithm)
+}
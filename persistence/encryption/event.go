@@ -0,0 +1,315 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mirror520/events"
+)
+
+var (
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// eventRepository encrypts the payloads of matching topics, and of every
+// event with a data subject, before they are stored by the next repository,
+// and decrypts them when they are fetched. Encrypted payloads are stored as
+// Bytes, with the ID of the data key set on the event.
+//
+// Events of a data subject are encrypted with the key of the subject, so
+// destroying that key crypto-shreds them: their payloads are then replaced
+// by an erased marker.
+type eventRepository struct {
+	next   events.Repository
+	keys   *KeyStore
+	topics []string
+}
+
+func NewEventRepository(next events.Repository, keys *KeyStore, topics []string) events.Repository {
+	return &eventRepository{
+		next:   next,
+		keys:   keys,
+		topics: topics,
+	}
+}
+
+func (repo *eventRepository) Unwrap() events.Repository {
+	return repo.next
+}
+
+func (repo *eventRepository) match(topic string) bool {
+	for _, pattern := range repo.topics {
+		if events.MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (repo *eventRepository) Store(e *events.Event) error {
+	// tombstones have nothing to encrypt, and must stay recognizable for compaction
+	if e.IsTombstone() || e.Subject == "" && !repo.match(e.Topic) {
+		return repo.next.Store(e)
+	}
+
+	encrypted, err := repo.encrypt(e)
+	if err != nil {
+		return err
+	}
+
+	return repo.next.Store(encrypted)
+}
+
+func (repo *eventRepository) encrypt(e *events.Event) (*events.Event, error) {
+	var (
+		id  string
+		key []byte
+		err error
+	)
+
+	if e.Subject != "" {
+		id, key, err = repo.keys.SubjectKey(e.Subject)
+	} else {
+		id, key, err = repo.keys.DataKey(e.Topic)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := e.Payload.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	// plaintext: DataType, uvarint length + type URL, raw data
+	buf := bytes.NewBuffer(make([]byte, 0, len(raw)+len(e.Payload.TypeURL)+8))
+	buf.WriteByte(byte(e.Payload.Type))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(e.Payload.TypeURL))))
+	buf.WriteString(e.Payload.TypeURL)
+	buf.Write(raw)
+
+	ciphertext, err := seal(key, buf.Bytes(), additionalData(e))
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := *e
+	encrypted.Payload.SetBytes(ciphertext)
+	encrypted.Payload.TypeURL = ""
+	encrypted.KeyID = id
+
+	return &encrypted, nil
+}
+
+func (repo *eventRepository) decrypt(e *events.Event) (*events.Event, error) {
+	if e.KeyID == "" {
+		return e, nil
+	}
+
+	key, err := repo.keys.Key(e.KeyID)
+	if err != nil {
+		if errors.Is(err, ErrKeyDestroyed) {
+			shredded := *e
+			shredded.Payload = events.NewErasedPayload("shredded")
+			shredded.KeyID = ""
+			return &shredded, nil
+		}
+
+		return nil, err
+	}
+
+	ciphertext, ok := e.Payload.Bytes()
+	if !ok {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := open(key, ciphertext, additionalData(e))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(plaintext)
+
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > uint64(r.Len()) {
+		return nil, ErrInvalidCiphertext
+	}
+
+	typeURL := make([]byte, size)
+	if _, err := io.ReadFull(r, typeURL); err != nil {
+		return nil, err
+	}
+
+	raw := plaintext[len(plaintext)-r.Len():]
+
+	payload, err := events.NewPayloadFromRaw(events.DataType(t), raw, string(typeURL))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := *e
+	decrypted.Payload = payload
+	decrypted.KeyID = ""
+
+	return &decrypted, nil
+}
+
+// additionalData binds a ciphertext to the ID and topic of its event.
+func additionalData(e *events.Event) []byte {
+	return append(e.ID.Bytes(), e.Topic...)
+}
+
+func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (events.Iterator, error) {
+	it, err := repo.next.Iterator(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterator{Iterator: it, repo: repo}, nil
+}
+
+func (repo *eventRepository) Retain(policy events.RetentionPolicy) (int, error) {
+	retainer, ok := repo.next.(events.Retainer)
+	if !ok {
+		return 0, events.ErrNotSupported
+	}
+
+	return retainer.Retain(policy)
+}
+
+func (repo *eventRepository) Compact(policy events.CompactionPolicy) (int, error) {
+	compactor, ok := repo.next.(events.Compactor)
+	if !ok {
+		return 0, events.ErrNotSupported
+	}
+
+	return compactor.Compact(policy)
+}
+
+func (repo *eventRepository) Delete(id ulid.ULID) error {
+	eraser, ok := repo.next.(events.Eraser)
+	if !ok {
+		return events.ErrNotSupported
+	}
+
+	return eraser.Delete(id)
+}
+
+func (repo *eventRepository) DeleteRange(topic string, from time.Time, to time.Time) (int, error) {
+	eraser, ok := repo.next.(events.Eraser)
+	if !ok {
+		return 0, events.ErrNotSupported
+	}
+
+	return eraser.DeleteRange(topic, from, to)
+}
+
+func (repo *eventRepository) Redact(id ulid.ULID, reason string) error {
+	eraser, ok := repo.next.(events.Eraser)
+	if !ok {
+		return events.ErrNotSupported
+	}
+
+	return eraser.Redact(id, reason)
+}
+
+func (repo *eventRepository) Health(ctx context.Context) error {
+	checker, ok := repo.next.(events.HealthChecker)
+	if !ok {
+		return nil
+	}
+
+	return checker.Health(ctx)
+}
+
+func (repo *eventRepository) Buffered() int {
+	buffered, ok := repo.next.(events.Buffered)
+	if !ok {
+		return 0
+	}
+
+	return buffered.Buffered()
+}
+
+func (repo *eventRepository) Flush(ctx context.Context) error {
+	flusher, ok := repo.next.(events.Flusher)
+	if !ok {
+		return nil
+	}
+
+	return flusher.Flush(ctx)
+}
+
+func (repo *eventRepository) ShredSubject(subject string) error {
+	_, err := repo.keys.DestroySubject(subject)
+	return err
+}
+
+func (repo *eventRepository) Close() error {
+	return repo.next.Close()
+}
+
+type iterator struct {
+	events.Iterator
+	repo *eventRepository
+
+	pending []*events.Event // fetched, but not decrypted
+	sync.Mutex
+}
+
+// Fetch decrypts the fetched events. Since the inner iterator has already
+// moved past them, the events from the first which fails to decrypt are held
+// back and fetched again by the next call.
+func (it *iterator) Fetch(batch int) ([]*events.Event, error) {
+	it.Lock()
+	defer it.Unlock()
+
+	es := it.pending
+	it.pending = nil
+
+	if len(es) == 0 {
+		fetched, err := it.Iterator.Fetch(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		es = fetched
+	}
+
+	// the fetched slice may be backed by the repository, so decrypt into a copy
+	decrypted := make([]*events.Event, 0, len(es))
+	for i, e := range es {
+		d, err := it.repo.decrypt(e)
+		if err != nil {
+			it.pending = slices.Clone(es[i:])
+			if len(decrypted) == 0 {
+				return nil, err
+			}
+
+			break
+		}
+
+		decrypted = append(decrypted, d)
+	}
+
+	return decrypted, nil
+}
@@ -0,0 +1,203 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/inmem"
+)
+
+func TestEncryption(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	storeFile := filepath.Join(dir, "keys.json")
+
+	keys, err := OpenKeyStore(keyFile, storeFile)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	next, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+	repo := NewEventRepository(next, keys, []string{"users/**"})
+	defer repo.Close()
+
+	secret := events.NewEvent("users/1", events.NewPayloadFromJSON(json.RawMessage(`{"name":"Alice"}`)))
+	public := events.NewEvent("hello/world", events.NewPayload("Hello World"))
+
+	for _, e := range []*events.Event{secret, public} {
+		if err := repo.Store(e); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	// stored payload is encrypted
+	{
+		it, _ := next.Iterator(context.TODO(), time.Time{})
+		defer it.Close(nil)
+
+		es, err := it.Fetch(2)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(events.Bytes, es[0].Payload.Type)
+		assert.NotEmpty(es[0].KeyID)
+		assert.NotContains(string(es[0].Payload.Data.([]byte)), "Alice")
+		assert.Equal(public, es[1])
+	}
+
+	// rotation re-wraps data keys, payloads remain readable
+	{
+		n, err := keys.Rotate()
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(1, n)
+
+		rotated, err := OpenKeyStore(keyFile, storeFile)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		repo := NewEventRepository(next, rotated, []string{"users/**"})
+
+		it, _ := repo.Iterator(context.TODO(), time.Time{})
+		defer it.Close(nil)
+
+		es, err := it.Fetch(2)
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		assert.Equal(secret, es[0])
+		assert.Equal(public, es[1])
+	}
+}
+
+func TestFetchUndecryptable(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	keys, err := OpenKeyStore(filepath.Join(dir, "master.key"), filepath.Join(dir, "keys.json"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	next, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+
+	public := events.NewEvent("hello/world", events.NewPayload("Hello World"))
+	secret := events.NewEvent("users/1", events.NewPayloadFromJSON(json.RawMessage(`{"name":"Alice"}`)))
+	last := events.NewEvent("hello/world", events.NewPayload("Goodbye"))
+
+	repo := NewEventRepository(next, keys, []string{"users/**"})
+	for _, e := range []*events.Event{public, secret, last} {
+		if err := repo.Store(e); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	// without the master key, the data key cannot be unwrapped
+	lost, err := OpenKeyStore(filepath.Join(dir, "lost.key"), filepath.Join(dir, "keys.json"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	repo = NewEventRepository(next, lost, []string{"users/**"})
+
+	it, _ := repo.Iterator(context.TODO(), time.Time{})
+	defer it.Close(nil)
+
+	es, err := it.Fetch(3)
+	if assert.NoError(err) && assert.Len(es, 1) {
+		assert.Equal(public, es[0])
+	}
+
+	_, err = it.Fetch(3)
+	assert.ErrorIs(err, ErrMasterKeyNotFound)
+
+	// the held events are fetched again once they can be decrypted
+	repo.(*eventRepository).keys = keys
+
+	es, err = it.Fetch(3)
+	if assert.NoError(err) && assert.Len(es, 2) {
+		assert.Equal(secret, es[0])
+		assert.Equal(last, es[1])
+	}
+}
+
+func TestCryptoShredding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	keys, err := OpenKeyStore(filepath.Join(dir, "master.key"), filepath.Join(dir, "keys.json"))
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	next, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+	repo := NewEventRepository(next, keys, nil)
+	defer repo.Close()
+
+	alice := events.NewEvent("users/signed_up", events.NewPayloadFromJSON(json.RawMessage(`{"name":"Alice"}`)))
+	alice.Subject = "alice"
+
+	bob := events.NewEvent("users/signed_up", events.NewPayloadFromJSON(json.RawMessage(`{"name":"Bob"}`)))
+	bob.Subject = "bob"
+
+	for _, e := range []*events.Event{alice, bob} {
+		if err := repo.Store(e); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+	}
+
+	shredder, ok := repo.(events.Shredder)
+	if !ok {
+		assert.Fail("invalid type")
+		return
+	}
+
+	if err := shredder.ShredSubject("alice"); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	it, _ := repo.Iterator(context.TODO(), time.Time{})
+	defer it.Close(nil)
+
+	es, err := it.Fetch(2)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Len(es, 2)
+	assert.Equal(alice.ID, es[0].ID)
+	assert.Equal(events.NewErasedPayload("shredded"), es[0].Payload)
+	assert.Equal(bob, es[1])
+
+	// unknown subject
+	err = shredder.ShredSubject("carol")
+	assert.ErrorIs(err, ErrKeyNotFound)
+}
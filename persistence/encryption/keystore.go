@@ -0,0 +1,450 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const keySize = 32 // AES-256
+
+var (
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrKeyDestroyed      = errors.New("key destroyed")
+	ErrMasterKeyNotFound = errors.New("master key not found")
+	ErrInvalidKey        = errors.New("invalid key")
+)
+
+type MasterKey struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+// MasterKeys is the content of the local keyfile. The current master key
+// wraps new data keys; older ones are kept until every data key has been
+// re-wrapped by a rotation.
+type MasterKeys struct {
+	Current string       `json:"current"`
+	Keys    []*MasterKey `json:"keys"`
+}
+
+func (mks *MasterKeys) Key(id string) (*MasterKey, error) {
+	for _, mk := range mks.Keys {
+		if mk.ID == id {
+			return mk, nil
+		}
+	}
+
+	return nil, ErrMasterKeyNotFound
+}
+
+func (mks *MasterKeys) add() (*MasterKey, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	mk := &MasterKey{
+		ID:  "mk-" + ulid.Make().String(),
+		Key: key,
+	}
+
+	mks.Keys = append(mks.Keys, mk)
+	mks.Current = mk.ID
+	return mk, nil
+}
+
+// DataKey is a data key of a topic or, when the subject is set, of a data
+// subject. A destroyed data key keeps its ID but no longer its key material.
+type DataKey struct {
+	ID        string `json:"id"`
+	Topic     string `json:"topic,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	MasterKey string `json:"master_key,omitempty"`
+	Wrapped   []byte `json:"wrapped,omitempty"`
+	Destroyed bool   `json:"destroyed,omitempty"`
+}
+
+func (dk *DataKey) scope() string {
+	if dk.Subject != "" {
+		return subjectScope(dk.Subject)
+	}
+
+	return topicScope(dk.Topic)
+}
+
+func topicScope(topic string) string {
+	return "topic:" + topic
+}
+
+func subjectScope(subject string) string {
+	return "subject:" + subject
+}
+
+// KeyStore manages per-topic and per-subject data keys. Data keys are
+// persisted wrapped by a master key from the keyfile, and kept unwrapped in
+// memory once used.
+type KeyStore struct {
+	keyFile   string
+	storeFile string
+
+	masters *MasterKeys
+	keys    map[string]*DataKey // id -> data key
+	current map[string]string   // scope -> id
+	plain   map[string][]byte   // id -> unwrapped data key
+	sync.RWMutex
+}
+
+// OpenKeyStore loads the master keys from the keyfile, creating it with a new
+// master key when it does not exist, and the wrapped data keys from the store.
+func OpenKeyStore(keyFile string, storeFile string) (*KeyStore, error) {
+	ks := &KeyStore{
+		keyFile:   keyFile,
+		storeFile: storeFile,
+		masters:   new(MasterKeys),
+		keys:      make(map[string]*DataKey),
+		current:   make(map[string]string),
+		plain:     make(map[string][]byte),
+	}
+
+	err := readJSON(keyFile, ks.masters)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := ks.masters.add(); err != nil {
+			return nil, err
+		}
+
+		err = writeJSON(keyFile, ks.masters)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*DataKey
+	if err := readJSON(storeFile, &keys); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, dk := range keys {
+		ks.keys[dk.ID] = dk
+		if !dk.Destroyed {
+			ks.current[dk.scope()] = dk.ID
+		}
+	}
+
+	return ks, nil
+}
+
+// DataKey returns the data key of the topic, creating it on first use.
+func (ks *KeyStore) DataKey(topic string) (string, []byte, error) {
+	return ks.dataKey(&DataKey{Topic: topic})
+}
+
+// SubjectKey returns the data key of the data subject, creating it on first
+// use. Once destroyed, a new data key is created for later events.
+func (ks *KeyStore) SubjectKey(subject string) (string, []byte, error) {
+	return ks.dataKey(&DataKey{Subject: subject})
+}
+
+func (ks *KeyStore) dataKey(dk *DataKey) (string, []byte, error) {
+	scope := dk.scope()
+
+	ks.RLock()
+	id, ok := ks.current[scope]
+	ks.RUnlock()
+
+	if ok {
+		key, err := ks.Key(id)
+		return id, key, err
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	if id, ok := ks.current[scope]; ok {
+		key, err := ks.key(id)
+		return id, key, err
+	}
+
+	mk, err := ks.masters.Key(ks.masters.Current)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", nil, err
+	}
+
+	wrapped, err := seal(mk.Key, key, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dk.ID = "dk-" + ulid.Make().String()
+	dk.MasterKey = mk.ID
+	dk.Wrapped = wrapped
+
+	ks.keys[dk.ID] = dk
+	if err := ks.save(); err != nil {
+		delete(ks.keys, dk.ID)
+		return "", nil, err
+	}
+
+	ks.current[scope] = dk.ID
+	ks.plain[dk.ID] = key
+
+	return dk.ID, key, nil
+}
+
+// Key returns the unwrapped data key with the given ID.
+func (ks *KeyStore) Key(id string) ([]byte, error) {
+	ks.RLock()
+	key, ok := ks.plain[id]
+	ks.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	return ks.key(id)
+}
+
+func (ks *KeyStore) key(id string) ([]byte, error) {
+	if key, ok := ks.plain[id]; ok {
+		return key, nil
+	}
+
+	dk, ok := ks.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	if dk.Destroyed {
+		return nil, ErrKeyDestroyed
+	}
+
+	mk, err := ks.masters.Key(dk.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := open(mk.Key, dk.Wrapped, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.plain[id] = key
+	return key, nil
+}
+
+// DestroySubject destroys every data key of the data subject, so that its
+// events can never be decrypted again. It returns the number of destroyed
+// data keys.
+func (ks *KeyStore) DestroySubject(subject string) (int, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	destroyed := make(map[string]*DataKey)
+	for id, dk := range ks.keys {
+		if dk.Subject != subject || dk.Destroyed {
+			continue
+		}
+
+		destroyed[id] = &DataKey{
+			ID:        dk.ID,
+			Subject:   dk.Subject,
+			Destroyed: true,
+		}
+	}
+
+	if len(destroyed) == 0 {
+		return 0, ErrKeyNotFound
+	}
+
+	keys := make(map[string]*DataKey, len(ks.keys))
+	for id, dk := range ks.keys {
+		keys[id] = dk
+	}
+
+	for id, dk := range destroyed {
+		keys[id] = dk
+	}
+
+	prev := ks.keys
+	ks.keys = keys
+	if err := ks.save(); err != nil {
+		ks.keys = prev
+		return 0, err
+	}
+
+	for id := range destroyed {
+		delete(ks.plain, id)
+	}
+
+	delete(ks.current, subjectScope(subject))
+	return len(destroyed), nil
+}
+
+// Rotate adds a new master key to the keyfile and re-wraps every data key
+// with it. Payloads are left untouched, since data keys do not change. It
+// returns the number of re-wrapped data keys.
+func (ks *KeyStore) Rotate() (int, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	masters := &MasterKeys{
+		Keys: append([]*MasterKey{}, ks.masters.Keys...),
+	}
+
+	mk, err := masters.add()
+	if err != nil {
+		return 0, err
+	}
+
+	// new master key is persisted first, so the data keys can always be unwrapped
+	if err := writeJSON(ks.keyFile, masters); err != nil {
+		return 0, err
+	}
+
+	return ks.rewrap(masters, mk)
+}
+
+// Rewrap re-wraps every data key with the current master key, e.g. those of
+// another store sharing the keyfile after its rotation. It returns the number
+// of re-wrapped data keys.
+func (ks *KeyStore) Rewrap() (int, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	mk, err := ks.masters.Key(ks.masters.Current)
+	if err != nil {
+		return 0, err
+	}
+
+	return ks.rewrap(ks.masters, mk)
+}
+
+func (ks *KeyStore) rewrap(masters *MasterKeys, mk *MasterKey) (int, error) {
+	rewrapped := make(map[string]*DataKey, len(ks.keys))
+	for id, dk := range ks.keys {
+		if dk.Destroyed {
+			rewrapped[id] = dk
+			continue
+		}
+
+		old, err := masters.Key(dk.MasterKey)
+		if err != nil {
+			return 0, err
+		}
+
+		key, err := open(old.Key, dk.Wrapped, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		wrapped, err := seal(mk.Key, key, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		rewrapped[id] = &DataKey{
+			ID:        dk.ID,
+			Topic:     dk.Topic,
+			Subject:   dk.Subject,
+			MasterKey: mk.ID,
+			Wrapped:   wrapped,
+		}
+	}
+
+	keys := ks.keys
+	ks.keys = rewrapped
+	if err := ks.save(); err != nil {
+		ks.keys = keys
+		return 0, err
+	}
+
+	ks.masters = masters
+	return len(rewrapped), nil
+}
+
+func (ks *KeyStore) save() error {
+	keys := make([]*DataKey, 0, len(ks.keys))
+	for _, dk := range ks.keys {
+		keys = append(keys, dk)
+	}
+
+	return writeJSON(ks.storeFile, keys)
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	size := aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrInvalidKey
+	}
+
+	return aead.Open(nil, ciphertext[:size], ciphertext[size:], additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func readJSON(name string, v any) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+// writeJSON replaces the file atomically, so a crash never leaves it empty.
+func writeJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -2,15 +2,49 @@ package persistence
 
 import (
 	"errors"
+	"path/filepath"
+	"strings"
 
 	"github.com/mirror520/events"
 	"github.com/mirror520/events/persistence/badger"
+	"github.com/mirror520/events/persistence/encryption"
 	"github.com/mirror520/events/persistence/influxdb"
 	"github.com/mirror520/events/persistence/inmem"
 	"github.com/mirror520/events/persistence/mongo"
 )
 
 func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
+	repo, err := newEventRepository(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if enc := cfg.Encryption; enc != nil {
+		keys, err := encryption.OpenKeyStore(enc.KeyFile, KeyStore(enc.KeyStore, cfg.Namespace))
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+
+		repo = encryption.NewEventRepository(repo, keys, enc.Topics)
+	}
+
+	return repo, nil
+}
+
+// KeyStore returns the key store of the namespace: each namespace has its own
+// data keys, e.g. keys.acme.json, so shredding a subject never reaches into
+// another namespace.
+func KeyStore(keyStore string, namespace string) string {
+	if events.IsDefaultNamespace(namespace) {
+		return keyStore
+	}
+
+	ext := filepath.Ext(keyStore)
+	return strings.TrimSuffix(keyStore, ext) + "." + namespace + ext
+}
+
+func newEventRepository(cfg events.Persistence) (events.Repository, error) {
 	switch cfg.Driver {
 	case events.InMem:
 		return inmem.NewEventRepository(cfg)
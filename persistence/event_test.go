@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"runtime"
 	"testing"
 	"time"
 
@@ -148,6 +149,109 @@ func (suite *persistenceTestSuite) TestBadgerPersistence() {
 	}
 }
 
+func (suite *persistenceTestSuite) TestBadgerNamespaces() {
+	dir := suite.T().TempDir()
+
+	open := func(namespace string) events.Repository {
+		repo, err := badger.NewEventRepository(events.Persistence{
+			Driver:    events.BadgerDB,
+			DSN:       dir,
+			Namespace: namespace,
+		})
+		if err != nil {
+			suite.T().Skip(err.Error())
+		}
+
+		return repo
+	}
+
+	// both namespaces share the database of the directory
+	defaults := open(events.DefaultNamespace)
+	defer defaults.Close()
+
+	acme := open("acme")
+
+	for _, e := range suite.dataset[:4] {
+		suite.NoError(defaults.Store(e))
+	}
+
+	for _, e := range suite.dataset[4:] {
+		suite.NoError(acme.Store(e))
+	}
+
+	fetch := func(repo events.Repository) []*events.Event {
+		it, _ := repo.Iterator(context.TODO(), time.Time{})
+		defer it.Close(nil)
+
+		time.Sleep(1000 * time.Millisecond)
+
+		es, _ := it.Fetch(len(suite.dataset))
+		return es
+	}
+
+	suite.Len(fetch(defaults), 4)
+	suite.Len(fetch(acme), 3)
+
+	// the events of another namespace are not found
+	suite.ErrorIs(acme.(events.Eraser).Delete(suite.dataset[0].ID), events.ErrEventNotFound)
+
+	n, err := acme.(events.Retainer).Retain(events.RetentionPolicy{
+		Topic:    "hello.*",
+		MaxCount: 1,
+	})
+	suite.NoError(err)
+	suite.Equal(2, n)
+
+	// closing a namespace keeps the shared database open
+	suite.NoError(acme.Close())
+	suite.Len(fetch(defaults), 4)
+}
+
+func (suite *persistenceTestSuite) TestBadgerPersistenceWithMessagePack() {
+	cfg := events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    "file::memory?codec=msgpack",
+	}
+
+	repo, err := badger.NewEventRepository(cfg)
+	if err != nil {
+		suite.T().Skip(err.Error())
+		return
+	}
+	defer repo.Close()
+
+	var errs error
+	for _, e := range suite.dataset {
+		err := repo.Store(e)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	if errs != nil {
+		suite.Fail(errs.Error())
+		return
+	}
+
+	it, _ := repo.Iterator(context.TODO(), time.Time{})
+	defer it.Close(nil)
+
+	time.Sleep(1000 * time.Millisecond)
+
+	size := len(suite.dataset)
+
+	es, err := it.Fetch(size)
+	if err != nil {
+		suite.Fail(err.Error())
+		return
+	}
+
+	suite.Len(es, size)
+	for i, e := range suite.dataset {
+		suite.Equal(e.Payload.Data, es[i].Payload.Data)
+	}
+}
+
 func (suite *persistenceTestSuite) TestInfluxDBPersistence() {
 	cfg := events.Persistence{
 		Driver: events.InfluxDB,
@@ -252,6 +356,325 @@ func (suite *persistenceTestSuite) TestMongoDBPersistence() {
 	}
 }
 
+func (suite *persistenceTestSuite) TestRetention() {
+	repos := make(map[string]events.Repository)
+	{
+		repo, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+		repos["inmem"] = repo
+	}
+	{
+		repo, err := badger.NewEventRepository(events.Persistence{
+			Driver: events.BadgerDB,
+			DSN:    "file::memory",
+		})
+		if err == nil {
+			repos["badger"] = repo
+		}
+	}
+
+	for name, repo := range repos {
+		defer repo.Close()
+
+		for _, e := range suite.dataset {
+			repo.Store(e)
+		}
+
+		retainer, ok := repo.(events.Retainer)
+		if !ok {
+			suite.Fail("retention not supported", name)
+			continue
+		}
+
+		// dataset events are 1 to 7 minutes old
+		n, err := retainer.Retain(events.RetentionPolicy{
+			Topic:  "hello.*",
+			MaxAge: 3*time.Minute + 30*time.Second,
+		})
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		suite.Equal(4, n, name)
+
+		// expired events are deleted once
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:  "hello.*",
+			MaxAge: 3*time.Minute + 30*time.Second,
+		})
+
+		suite.Zero(n, name)
+
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:    "hello.*",
+			MaxCount: 2,
+		})
+
+		suite.Equal(1, n, name)
+
+		// other topics are left untouched
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:    "other.*",
+			MaxCount: 1,
+		})
+
+		suite.Zero(n, name)
+
+		// reserved topics are only retained by policies of their own
+		id := ulid.Make()
+		id.SetTime(uint64(time.Now().Add(-10 * time.Minute).UnixMilli()))
+		repo.Store(events.NewEvent(events.AuditTopic, events.NewPayload("audited"), id))
+
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:  "**",
+			MaxAge: time.Minute,
+		})
+
+		suite.Equal(2, n, name)
+
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:  events.AuditTopic,
+			MaxAge: time.Minute,
+		})
+
+		suite.Equal(1, n, name)
+
+		// later sweeps delete the events stored since
+		for i := 0; i < 3; i++ {
+			repo.Store(events.NewEvent("hello.world", events.NewPayload(i)))
+		}
+
+		n, _ = retainer.Retain(events.RetentionPolicy{
+			Topic:    "hello.*",
+			MaxCount: 2,
+		})
+
+		suite.Equal(1, n, name)
+	}
+}
+
+func (suite *persistenceTestSuite) TestCompaction() {
+	repos := make(map[string]events.Repository)
+	{
+		repo, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+		repos["inmem"] = repo
+	}
+	{
+		repo, err := badger.NewEventRepository(events.Persistence{
+			Driver: events.BadgerDB,
+			DSN:    "file::memory",
+		})
+		if err == nil {
+			repos["badger"] = repo
+		}
+	}
+
+	newEvent := func(minutes int, topic string, key string, data any) *events.Event {
+		var payload events.Payload
+		payload.SetData(data)
+
+		e := events.NewEvent(topic, payload)
+		e.ID.SetTime(ulid.Timestamp(time.Now().Add(-time.Duration(minutes) * time.Minute)))
+		e.Key = key
+		return e
+	}
+
+	for name, repo := range repos {
+		defer repo.Close()
+
+		repo.Store(newEvent(6, "state.users", "alice", "v1"))
+		repo.Store(newEvent(5, "state.users", "bob", "v1"))
+		repo.Store(newEvent(4, "state.users", "alice", "v2"))
+		repo.Store(newEvent(3, "state.users", "bob", nil)) // tombstone
+		repo.Store(newEvent(2, "state.users", "", "unkeyed"))
+		repo.Store(newEvent(1, "other.users", "alice", "v1"))
+		repo.Store(newEvent(0, "other.users", "alice", "v2"))
+
+		compactor, ok := repo.(events.Compactor)
+		if !ok {
+			suite.Fail("compaction not supported", name)
+			continue
+		}
+
+		n, err := compactor.Compact(events.CompactionPolicy{Topic: "state.*"})
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		suite.Equal(2, n, name)
+
+		// tombstones older than the delete retention are dropped
+		n, _ = compactor.Compact(events.CompactionPolicy{
+			Topic:           "state.*",
+			DeleteRetention: 2 * time.Minute,
+		})
+
+		suite.Equal(1, n, name)
+
+		it, err := repo.Iterator(context.TODO(), time.Time{})
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		// wait for the iterator to be ready
+		time.Sleep(1000 * time.Millisecond)
+
+		es, err := it.Fetch(10)
+		it.Close(nil)
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		if suite.Len(es, 4, name) {
+			suite.Equal("v2", es[0].Payload.Data, name)
+			suite.Equal("alice", es[0].Key, name)
+			suite.Equal("unkeyed", es[1].Payload.Data, name)
+		}
+	}
+}
+
+func (suite *persistenceTestSuite) TestErase() {
+	repos := make(map[string]events.Repository)
+	{
+		repo, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+		repos["inmem"] = repo
+	}
+	{
+		repo, err := badger.NewEventRepository(events.Persistence{
+			Driver: events.BadgerDB,
+			DSN:    "file::memory",
+		})
+		if err == nil {
+			repos["badger"] = repo
+		}
+	}
+
+	for name, repo := range repos {
+		defer repo.Close()
+
+		for _, e := range suite.dataset {
+			repo.Store(e)
+		}
+
+		eraser, ok := repo.(events.Eraser)
+		if !ok {
+			suite.Fail("erasure not supported", name)
+			continue
+		}
+
+		first, last := suite.dataset[0], suite.dataset[len(suite.dataset)-1]
+
+		err := eraser.Delete(first.ID)
+		suite.NoError(err, name)
+
+		err = eraser.Delete(first.ID)
+		suite.ErrorIs(err, events.ErrEventNotFound, name)
+
+		err = eraser.Redact(last.ID, "gdpr")
+		suite.NoError(err, name)
+
+		// dataset events are 1 to 7 minutes old
+		now := time.Now()
+		n, err := eraser.DeleteRange("hello.*", now.Add(-5*time.Minute), now.Add(-3*time.Minute))
+		suite.NoError(err, name)
+		suite.Equal(2, n, name)
+
+		// wildcards never select reserved topics
+		id := ulid.Make()
+		id.SetTime(uint64(now.Add(-10 * time.Minute).UnixMilli()))
+		repo.Store(events.NewEvent(events.AuditTopic, events.NewPayload("audited"), id))
+
+		n, err = eraser.DeleteRange("**", now.Add(-time.Hour), now.Add(-9*time.Minute))
+		suite.NoError(err, name)
+		suite.Zero(n, name)
+
+		n, err = eraser.DeleteRange(events.AuditTopic, now.Add(-time.Hour), now.Add(-9*time.Minute))
+		suite.NoError(err, name)
+		suite.Equal(1, n, name)
+
+		it, err := repo.Iterator(context.TODO(), time.Time{})
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		// wait for the iterator to be ready
+		time.Sleep(1000 * time.Millisecond)
+
+		es, err := it.Fetch(10)
+		it.Close(nil)
+		if err != nil {
+			suite.Fail(err.Error(), name)
+			continue
+		}
+
+		if suite.Len(es, len(suite.dataset)-3, name) {
+			redacted := es[len(es)-1]
+			suite.Equal(last.ID, redacted.ID, name)
+			suite.Equal(last.Topic, redacted.Topic, name)
+
+			raw, _ := redacted.Payload.Raw()
+			suite.JSONEq(`{"$erased":"gdpr"}`, string(raw), name)
+		}
+	}
+}
+
+func (suite *persistenceTestSuite) TestIteratorGoroutines() {
+	repo, err := badger.NewEventRepository(events.Persistence{
+		Driver: events.BadgerDB,
+		DSN:    "file::memory",
+	})
+	if err != nil {
+		suite.Fail(err.Error())
+		return
+	}
+	defer repo.Close()
+
+	// more events than an iterator prefetches, so its scan blocks
+	for i := 0; i < 50; i++ {
+		repo.Store(events.NewEvent("hello.world", events.NewPayload(i)))
+	}
+
+	svc := events.NewService(repo, events.WithIdleTimeout(1500*time.Millisecond))
+	svc.Up()
+	defer svc.Down()
+
+	baseline := runtime.NumGoroutine()
+
+	ids := make([]string, 20)
+	for i := range ids {
+		id, err := svc.NewIterator("hello.*", time.Time{})
+		if err != nil {
+			suite.Fail(err.Error())
+			return
+		}
+
+		ids[i] = id
+	}
+
+	// wait for the scans to fill the prefetch buffers
+	time.Sleep(time.Second)
+
+	suite.Greater(runtime.NumGoroutine(), baseline)
+
+	// half of the iterators are closed, the others expire
+	for _, id := range ids[:10] {
+		suite.NoError(svc.CloseIterator(id, ""))
+	}
+
+	// polled here, since Eventually runs the condition in a goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	suite.LessOrEqual(runtime.NumGoroutine(), baseline)
+	suite.Empty(svc.Iterators(""))
+}
+
 func TestPersistenceTestSuite(t *testing.T) {
 	suite.Run(t, new(persistenceTestSuite))
 }
@@ -6,19 +6,23 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"sync"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/oklog/ulid/v2"
 	"go.uber.org/zap"
 
+	"github.com/influxdata/influxdb1-client/models"
 	influx "github.com/influxdata/influxdb1-client/v2"
 
 	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/buffer"
 )
 
 type EventRepository interface {
 	events.Repository
+	events.Buffered
 	Exec(command string) error
 }
 
@@ -26,9 +30,10 @@ type eventRepository struct {
 	log    *zap.Logger
 	cfg    *Config
 	client influx.Client
-	points []*influx.Point
+	points *buffer.Buffer[*influx.Point]
+	stats  *events.DriverMetrics
 	cancel context.CancelFunc
-	sync.Mutex
+	done   chan struct{} // closed once the pending points are written
 }
 
 func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
@@ -37,6 +42,13 @@ func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
 		return nil, err
 	}
 
+	// each namespace has its own database, e.g. events_acme
+	namespace := events.DefaultNamespace
+	if !events.IsDefaultNamespace(cfg.Namespace) {
+		namespace = cfg.Namespace
+		conf.Database += "_" + strings.ReplaceAll(cfg.Namespace, "-", "_")
+	}
+
 	client, err := influx.NewHTTPClient(conf.HTTPConfig)
 	if err != nil {
 		return nil, err
@@ -50,11 +62,40 @@ func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
 	repo := &eventRepository{
 		log: zap.L().With(
 			zap.String("persistence", "influxdb"),
+			zap.String("namespace", namespace),
 		),
 		cfg:    conf,
 		client: client,
-		points: make([]*influx.Point, 0),
+		stats:  cfg.Metrics.Driver("influxdb", namespace),
 		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	points, err := buffer.New[*influx.Point](cfg.Buffer, "influxdb-"+namespace, lineProtocol{}, repo.stats.PendingEvents)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	repo.points = points
+
+	// a retention policy of all topics is enforced by the server
+	for _, policy := range cfg.Retention {
+		if policy.AllTopics() && policy.MaxAge > 0 {
+			if err := repo.setRetentionPolicy(conf.RetentionPolicy, policy.MaxAge); err != nil {
+				cancel()
+				return nil, err
+			}
+
+			break
+		}
+	}
+
+	// reserved topics, e.g. the audit trail, are kept in a retention policy of
+	// their own, which the server never expires
+	if err := repo.setRetentionPolicy(reservedPolicy, 0); err != nil {
+		cancel()
+		return nil, err
 	}
 
 	go repo.batchWriteHandler(ctx)
@@ -68,76 +109,138 @@ func (repo *eventRepository) batchWriteHandler(ctx context.Context) {
 	)
 
 	ticker := time.NewTicker(repo.cfg.Duration)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			repo.Lock()
-			if size := len(repo.points); size > 0 {
-				log := log.With(zap.Int("points", size))
-
-				bp, err := influx.NewBatchPoints(repo.cfg.BatchPointsConfig)
-				if err != nil {
-					log.Error(err.Error())
-				} else {
-					bp.AddPoints(repo.points)
-
-					if err := repo.client.Write(bp); err != nil {
-						log.Error(err.Error())
-					} else {
-						log.Info("points written")
-					}
-				}
-			}
-
-			repo.points = make([]*influx.Point, 0)
-			repo.Unlock()
+			repo.flush(log)
+			close(repo.done)
 
 			log.Info("done")
 			return
 
 		case <-ticker.C:
-			repo.Lock()
-			if size := len(repo.points); size > 0 {
-				log := log.With(zap.Int("points", size))
+			repo.flush(log)
 
-				bp, err := influx.NewBatchPoints(repo.cfg.BatchPointsConfig)
-				if err != nil {
-					log.Error(err.Error())
-				} else {
-					bp.AddPoints(repo.points)
-
-					if err := repo.client.Write(bp); err != nil {
-						log.Error(err.Error())
-					} else {
-						log.Info("points written")
-					}
-				}
+		case <-repo.points.Flush():
+			repo.flush(log)
+		}
+	}
+}
+
+// flush writes the pending points. Points failing to be written are retried
+// by the next flush, unless the server rejected them.
+func (repo *eventRepository) flush(log *zap.Logger) error {
+	begin := time.Now()
+
+	n, err := repo.points.Drain(func(points []*influx.Point) error {
+		batches := make(map[string][]*influx.Point)
+		for _, p := range points {
+			rp := repo.retentionPolicy(p.Tags()["topic"])
+			batches[rp] = append(batches[rp], p)
+		}
+
+		for rp, points := range batches {
+			conf := repo.cfg.BatchPointsConfig
+			conf.RetentionPolicy = rp
+
+			bp, err := influx.NewBatchPoints(conf)
+			if err != nil {
+				return buffer.Permanent(err)
 			}
 
-			repo.points = make([]*influx.Point, 0)
-			repo.Unlock()
+			bp.AddPoints(points)
+
+			err = repo.client.Write(bp)
+			if rejected(err) {
+				return buffer.Permanent(err)
+			}
+
+			if err != nil {
+				return err
+			}
 		}
+
+		return nil
+	})
+
+	if n == 0 && err == nil {
+		return nil
 	}
+
+	repo.stats.FlushDuration.Observe(time.Since(begin).Seconds())
+
+	log = log.With(zap.Int("points", n))
+
+	if err != nil {
+		repo.stats.FlushFailures.Add(1)
+		log.Error(err.Error(), zap.Int("pending", repo.points.Len()))
+		return err
+	}
+
+	log.Info("points written")
+	return nil
+}
+
+// Flush writes the pending points now; the writes time out by the timeout of
+// the client, not by the context.
+func (repo *eventRepository) Flush(ctx context.Context) error {
+	log := repo.log.With(
+		zap.String("action", "flush"),
+	)
+
+	return repo.flush(log)
 }
 
+// rejected reports whether the server rejected the points themselves, e.g. a
+// field of another type, so they would be rejected again; the valid points
+// of a partial write are written.
+func rejected(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "partial write") ||
+		strings.Contains(msg, "unable to parse") ||
+		strings.Contains(msg, "field type conflict")
+}
+
+// Store buffers the point of the event, which may fail with
+// events.ErrBackpressure once the buffer is full.
 func (repo *eventRepository) Store(e *events.Event) error {
 	tags := map[string]string{
 		"topic": e.Topic,
 	}
 
-	data, err := json.Marshal(e.Payload.Data)
+	data, err := json.Marshal(&e.Payload)
 	if err != nil {
 		return err
 	}
 
 	jsonStr := string(data)
-	if e.Payload.Type == events.Bytes {
-		jsonStr = fmt.Sprintf(`{"$binary":%s}`, jsonStr)
-	}
 
 	fields := map[string]any{
 		"id":      e.ID.String(),
 		"payload": jsonStr,
+		"version": e.Version,
+	}
+
+	if e.KeyID != "" {
+		fields["key_id"] = e.KeyID
+	}
+
+	if e.Subject != "" {
+		fields["subject"] = e.Subject
+	}
+
+	if e.Key != "" {
+		fields["key"] = e.Key
+	}
+
+	if e.TraceParent != "" {
+		fields["traceparent"] = e.TraceParent
 	}
 
 	ts := time.UnixMilli(int64(e.ID.Time()))
@@ -147,10 +250,26 @@ func (repo *eventRepository) Store(e *events.Event) error {
 		return err
 	}
 
-	repo.Lock()
-	repo.points = append(repo.points, point)
-	repo.Unlock()
-	return nil
+	return repo.points.Push(point)
+}
+
+func (repo *eventRepository) Buffered() int {
+	return repo.points.Len()
+}
+
+// Health pings the server, and checks that the pending points are written in
+// time.
+func (repo *eventRepository) Health(ctx context.Context) error {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if _, _, err := repo.client.Ping(timeout); err != nil {
+		return err
+	}
+
+	return repo.points.Check()
 }
 
 func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (events.Iterator, error) {
@@ -170,11 +289,35 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 	}, nil
 }
 
+// fetch returns the next events of every retention policy, in the order of
+// their IDs.
 func (repo *eventRepository) fetch(batch int, last ulid.ULID) ([]*events.Event, error) {
+	es := make([]*events.Event, 0)
+	for _, source := range repo.sources() {
+		fetched, err := repo.fetchFrom(source, batch, last)
+		if err != nil {
+			return nil, err
+		}
+
+		es = append(es, fetched...)
+	}
+
+	if len(es) == 0 {
+		return nil, events.ErrEventEmpty
+	}
+
+	slices.SortFunc(es, func(a, b *events.Event) int {
+		return a.ID.Compare(b.ID)
+	})
+
+	return es[:min(batch, len(es))], nil
+}
+
+func (repo *eventRepository) fetchFrom(source string, batch int, last ulid.ULID) ([]*events.Event, error) {
 	ms := last.Time()
 
-	query := fmt.Sprintf(`SELECT id, topic, payload FROM %s WHERE time > %dms LIMIT %d`,
-		repo.cfg.Measurement, ms, batch)
+	query := fmt.Sprintf(`SELECT id, topic, payload, version, key_id, subject, "key", traceparent FROM %s WHERE time > %dms LIMIT %d`,
+		source, ms, batch)
 
 	q := influx.NewQuery(query, repo.cfg.Database, "")
 
@@ -189,7 +332,7 @@ func (repo *eventRepository) fetch(batch int, last ulid.ULID) ([]*events.Event,
 
 	series := resp.Results[0].Series
 	if len(series) == 0 || len(series[0].Values) == 0 {
-		return nil, errors.New("event empty")
+		return nil, nil
 	}
 
 	row := series[0]
@@ -216,21 +359,435 @@ func (repo *eventRepository) fetch(batch int, last ulid.ULID) ([]*events.Event,
 			Payload: payload,
 		}
 
+		// points written before versioning have no version field
+		if version, ok := value[4].(json.Number); ok {
+			v, err := version.Int64()
+			if err != nil {
+				return nil, err
+			}
+
+			e.Version = int(v)
+		}
+
+		if keyID, ok := value[5].(string); ok {
+			e.KeyID = keyID
+		}
+
+		if subject, ok := value[6].(string); ok {
+			e.Subject = subject
+		}
+
+		if key, ok := value[7].(string); ok {
+			e.Key = key
+		}
+
+		if traceParent, ok := value[8].(string); ok {
+			e.TraceParent = traceParent
+		}
+
 		es[i] = e
 	}
 
 	return es, nil
 }
 
+// setRetentionPolicy sets the duration of the retention policy, or of the
+// default "autogen" policy of the database; it never expires without a
+// duration.
+func (repo *eventRepository) setRetentionPolicy(rp string, d time.Duration) error {
+	if rp == "" {
+		rp = "autogen"
+	}
+
+	duration := "INF"
+	if d > 0 {
+		duration = fmt.Sprintf("%ds", int64(d.Seconds()))
+	}
+
+	create := fmt.Sprintf(`CREATE RETENTION POLICY "%s" ON "%s" DURATION %s REPLICATION 1`,
+		rp, repo.cfg.Database, duration)
+
+	if err := repo.Exec(create); err == nil {
+		return nil
+	}
+
+	alter := fmt.Sprintf(`ALTER RETENTION POLICY "%s" ON "%s" DURATION %s`,
+		rp, repo.cfg.Database, duration)
+
+	return repo.Exec(alter)
+}
+
+// reservedPolicy is the retention policy of the reserved topics.
+const reservedPolicy = "reserved"
+
+// retentionPolicy returns the retention policy the points of the topic are
+// written to, where empty is the default policy of the database.
+func (repo *eventRepository) retentionPolicy(topic string) string {
+	if events.IsReservedTopic(topic) {
+		return reservedPolicy
+	}
+
+	return repo.cfg.RetentionPolicy
+}
+
+// from returns the measurement of the topic, qualified by its retention
+// policy; points are deleted from every policy by their topic.
+func (repo *eventRepository) from(topic string) string {
+	return repo.qualify(repo.retentionPolicy(topic))
+}
+
+// sources returns the measurements of all topics.
+func (repo *eventRepository) sources() []string {
+	return []string{
+		repo.qualify(repo.cfg.RetentionPolicy),
+		repo.qualify(reservedPolicy),
+	}
+}
+
+func (repo *eventRepository) qualify(rp string) string {
+	if rp == "" {
+		return repo.cfg.Measurement
+	}
+
+	return fmt.Sprintf(`"%s".%s`, rp, repo.cfg.Measurement)
+}
+
+// topics returns the topics of the written points.
+func (repo *eventRepository) topics() ([]string, error) {
+	topics := make([]string, 0)
+	for _, source := range repo.sources() {
+		results, err := repo.query(fmt.Sprintf(`SHOW TAG VALUES FROM %s WITH KEY = "topic"`, source))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(results) == 0 || len(results[0].Series) == 0 {
+			continue
+		}
+
+		for _, value := range results[0].Series[0].Values {
+			if topic, ok := value[1].(string); ok {
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	return topics, nil
+}
+
+func (repo *eventRepository) query(command string) ([]influx.Result, error) {
+	q := influx.NewQuery(command, repo.cfg.Database, "")
+
+	resp, err := repo.client.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resp.Error(); err != nil {
+		return nil, err
+	}
+
+	return resp.Results, nil
+}
+
+func (repo *eventRepository) Retain(policy events.RetentionPolicy) (int, error) {
+	topics, err := repo.topics()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, topic := range topics {
+		if !policy.Match(topic) {
+			continue
+		}
+
+		quoted := strings.ReplaceAll(topic, `'`, `\'`)
+
+		var cutoff time.Time
+		if policy.MaxAge > 0 {
+			cutoff = time.Now().Add(-policy.MaxAge)
+		}
+
+		if policy.MaxCount > 0 {
+			// time of the oldest point to keep
+			results, err := repo.query(fmt.Sprintf(
+				`SELECT id FROM %s WHERE topic = '%s' ORDER BY time DESC LIMIT 1 OFFSET %d`,
+				repo.from(topic), quoted, policy.MaxCount-1))
+			if err != nil {
+				return deleted, err
+			}
+
+			if len(results) > 0 && len(results[0].Series) > 0 && len(results[0].Series[0].Values) > 0 {
+				tsStr, _ := results[0].Series[0].Values[0][0].(string)
+				ts, err := time.Parse(time.RFC3339Nano, tsStr)
+				if err != nil {
+					return deleted, err
+				}
+
+				if ts.After(cutoff) {
+					cutoff = ts
+				}
+			}
+		}
+
+		if cutoff.IsZero() {
+			continue
+		}
+
+		where := fmt.Sprintf(`topic = '%s' AND time < %dms`, quoted, cutoff.UnixMilli())
+
+		count, err := repo.deleteWhere(topic, where)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteWhere deletes the points of the topic matching the condition, which
+// may only refer to tags and time, and returns their number.
+func (repo *eventRepository) deleteWhere(topic string, where string) (int, error) {
+	results, err := repo.query(fmt.Sprintf(`SELECT count(id) FROM %s WHERE %s`,
+		repo.from(topic), where))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 || len(results[0].Series) == 0 {
+		return 0, nil
+	}
+
+	number, ok := results[0].Series[0].Values[0][1].(json.Number)
+	if !ok {
+		return 0, nil
+	}
+
+	count, err := number.Int64()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if _, err := repo.query(fmt.Sprintf(`DELETE FROM %s WHERE %s`,
+		repo.cfg.Measurement, where)); err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+func (repo *eventRepository) Delete(id ulid.ULID) error {
+	n, err := repo.deletePending(func(p *influx.Point, fields map[string]any) bool {
+		return fields["id"] == id.String()
+	})
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	topic, _, err := repo.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	where := fmt.Sprintf(`topic = '%s' AND time = %dms`,
+		strings.ReplaceAll(topic, `'`, `\'`), id.Time())
+
+	_, err = repo.deleteWhere(topic, where)
+	return err
+}
+
+func (repo *eventRepository) DeleteRange(topic string, from time.Time, to time.Time) (int, error) {
+	deleted, err := repo.deletePending(func(p *influx.Point, fields map[string]any) bool {
+		ts := p.Time()
+		return !ts.Before(from) && ts.Before(to) && events.MatchTopic(topic, p.Tags()["topic"])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	topics, err := repo.topics()
+	if err != nil {
+		return deleted, err
+	}
+
+	for _, t := range topics {
+		if !events.MatchTopic(topic, t) {
+			continue
+		}
+
+		where := fmt.Sprintf(`topic = '%s' AND time >= %dms AND time < %dms`,
+			strings.ReplaceAll(t, `'`, `\'`), from.UnixMilli(), to.UnixMilli())
+
+		count, err := repo.deleteWhere(t, where)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Redact overwrites the point of the event, since a point with the same
+// series and timestamp replaces the fields of the former one.
+func (repo *eventRepository) Redact(id ulid.ULID, reason string) error {
+	data, err := json.Marshal(events.NewErasedPayload(reason))
+	if err != nil {
+		return err
+	}
+
+	redact := func(fields map[string]any) {
+		fields["payload"] = string(data)
+		fields["key_id"] = ""
+	}
+
+	var (
+		redacted bool
+		errs     error
+	)
+
+	err = repo.points.Update(func(points []*influx.Point) []*influx.Point {
+		for i, p := range points {
+			fields, err := p.Fields()
+			if err != nil || fields["id"] != id.String() {
+				continue
+			}
+
+			redact(fields)
+
+			point, err := influx.NewPoint(p.Name(), p.Tags(), fields, p.Time())
+			if err != nil {
+				errs = errors.Join(errs, err)
+				continue
+			}
+
+			points[i] = point
+			redacted = true
+		}
+
+		return points
+	})
+
+	if err := errors.Join(err, errs); err != nil || redacted {
+		return err
+	}
+
+	topic, fields, err := repo.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	redact(fields)
+
+	tags := map[string]string{
+		"topic": topic,
+	}
+
+	point, err := influx.NewPoint(repo.cfg.Measurement, tags, fields, time.UnixMilli(int64(id.Time())))
+	if err != nil {
+		return err
+	}
+
+	conf := repo.cfg.BatchPointsConfig
+	conf.RetentionPolicy = repo.retentionPolicy(topic)
+
+	bp, err := influx.NewBatchPoints(conf)
+	if err != nil {
+		return err
+	}
+
+	bp.AddPoint(point)
+	return repo.client.Write(bp)
+}
+
+// lookup returns the topic and the fields of the written point of the event.
+func (repo *eventRepository) lookup(id ulid.ULID) (string, map[string]any, error) {
+	var row *models.Row
+	for _, source := range repo.sources() {
+		results, err := repo.query(fmt.Sprintf(
+			`SELECT id, topic, payload, version, key_id, subject, "key" FROM %s WHERE time = %dms AND id = '%s'`,
+			source, id.Time(), id.String()))
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(results) > 0 && len(results[0].Series) > 0 && len(results[0].Series[0].Values) > 0 {
+			row = &results[0].Series[0]
+			break
+		}
+	}
+
+	if row == nil {
+		return "", nil, events.ErrEventNotFound
+	}
+
+	value := row.Values[0]
+
+	topic, _ := value[2].(string)
+
+	fields := make(map[string]any)
+	for i, column := range row.Columns {
+		if i == 0 || column == "topic" || value[i] == nil {
+			continue
+		}
+
+		fields[column] = value[i]
+	}
+
+	// numbers are decoded as json.Number, and must be written back as such
+	if version, ok := fields["version"].(json.Number); ok {
+		v, err := version.Int64()
+		if err != nil {
+			return "", nil, err
+		}
+
+		fields["version"] = v
+	}
+
+	return topic, fields, nil
+}
+
+// deletePending drops the matching points not written yet, and returns their
+// number.
+func (repo *eventRepository) deletePending(match func(p *influx.Point, fields map[string]any) bool) (int, error) {
+	deleted := 0
+	err := repo.points.Update(func(points []*influx.Point) []*influx.Point {
+		kept := make([]*influx.Point, 0, len(points))
+		for _, p := range points {
+			fields, err := p.Fields()
+			if err == nil && match(p, fields) {
+				deleted++
+				continue
+			}
+
+			kept = append(kept, p)
+		}
+
+		return kept
+	})
+
+	return deleted, err
+}
+
+// Close writes the pending points before closing the client.
 func (repo *eventRepository) Close() error {
 	if repo.cancel != nil {
 		repo.cancel()
 		repo.cancel = nil
 
-		time.Sleep(3 * time.Second)
+		<-repo.done
 	}
 
-	return repo.client.Close()
+	return errors.Join(
+		repo.points.Close(),
+		repo.client.Close(),
+	)
 }
 
 func (repo *eventRepository) Exec(command string) error {
@@ -343,3 +900,23 @@ func parseConfig(dsn string) (*Config, error) {
 		Duration:    duration,
 	}, nil
 }
+
+// lineProtocol encodes the points spilled to disk.
+type lineProtocol struct{}
+
+func (lineProtocol) Marshal(p *influx.Point) ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (lineProtocol) Unmarshal(data []byte) (*influx.Point, error) {
+	points, err := models.ParsePoints(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(points) != 1 {
+		return nil, errors.New("invalid point")
+	}
+
+	return influx.NewPointFrom(points[0]), nil
+}
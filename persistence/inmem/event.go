@@ -2,7 +2,7 @@ package inmem
 
 import (
 	"context"
-	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -76,7 +76,7 @@ func (repo *eventRepository) fetch(batch int, last ulid.ULID) ([]*events.Event,
 	}
 
 	if start < 0 {
-		return nil, errors.New("event empty")
+		return nil, events.ErrEventEmpty
 	}
 
 	if start+batch < end {
@@ -86,6 +86,165 @@ func (repo *eventRepository) fetch(batch int, last ulid.ULID) ([]*events.Event,
 	return repo.events[start:end], nil
 }
 
+func (repo *eventRepository) Retain(policy events.RetentionPolicy) (int, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	var cutoff ulid.ULID
+	if policy.MaxAge > 0 {
+		cutoff.SetTime(ulid.Timestamp(time.Now().Add(-policy.MaxAge)))
+	}
+
+	// events are sorted, so the newest matching ones are kept from the end
+	keep := make([]bool, len(repo.events))
+	count := 0
+	for i := len(repo.events) - 1; i >= 0; i-- {
+		e := repo.events[i]
+		if !policy.Match(e.Topic) {
+			keep[i] = true
+			continue
+		}
+
+		if policy.MaxAge > 0 && e.ID.Compare(cutoff) < 0 {
+			continue
+		}
+
+		if policy.MaxCount > 0 && count >= policy.MaxCount {
+			continue
+		}
+
+		keep[i] = true
+		count++
+	}
+
+	es := make([]*events.Event, 0, len(repo.events))
+	for i, e := range repo.events {
+		if keep[i] {
+			es = append(es, e)
+		}
+	}
+
+	deleted := len(repo.events) - len(es)
+	repo.events = es
+
+	return deleted, nil
+}
+
+func (repo *eventRepository) Compact(policy events.CompactionPolicy) (int, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	var cutoff ulid.ULID
+	if policy.DeleteRetention > 0 {
+		cutoff.SetTime(ulid.Timestamp(time.Now().Add(-policy.DeleteRetention)))
+	}
+
+	// events are sorted, so the latest event of each key is found first from the end
+	keep := make([]bool, len(repo.events))
+	latest := make(map[string]struct{})
+	for i := len(repo.events) - 1; i >= 0; i-- {
+		e := repo.events[i]
+		if e.Key == "" || !policy.Match(e.Topic) {
+			keep[i] = true
+			continue
+		}
+
+		topicKey := e.Topic + "\x00" + e.Key
+		if _, ok := latest[topicKey]; ok {
+			continue
+		}
+
+		latest[topicKey] = struct{}{}
+
+		if e.IsTombstone() && policy.DeleteRetention > 0 && e.ID.Compare(cutoff) < 0 {
+			continue
+		}
+
+		keep[i] = true
+	}
+
+	es := make([]*events.Event, 0, len(repo.events))
+	for i, e := range repo.events {
+		if keep[i] {
+			es = append(es, e)
+		}
+	}
+
+	deleted := len(repo.events) - len(es)
+	repo.events = es
+
+	return deleted, nil
+}
+
+func (repo *eventRepository) Delete(id ulid.ULID) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	i, ok := repo.index(id)
+	if !ok {
+		return events.ErrEventNotFound
+	}
+
+	es := make([]*events.Event, 0, len(repo.events)-1)
+	es = append(es, repo.events[:i]...)
+	es = append(es, repo.events[i+1:]...)
+
+	repo.events = es
+	return nil
+}
+
+func (repo *eventRepository) DeleteRange(topic string, from time.Time, to time.Time) (int, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	lower, upper := ulid.Timestamp(from), ulid.Timestamp(to)
+
+	es := make([]*events.Event, 0, len(repo.events))
+	for _, e := range repo.events {
+		ms := e.ID.Time()
+		if ms >= lower && ms < upper && events.MatchTopic(topic, e.Topic) {
+			continue
+		}
+
+		es = append(es, e)
+	}
+
+	deleted := len(repo.events) - len(es)
+	repo.events = es
+
+	return deleted, nil
+}
+
+func (repo *eventRepository) Redact(id ulid.ULID, reason string) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	i, ok := repo.index(id)
+	if !ok {
+		return events.ErrEventNotFound
+	}
+
+	// fetched events may still be read, so the event is replaced by a copy
+	redacted := *repo.events[i]
+	redacted.Payload = events.NewErasedPayload(reason)
+	redacted.KeyID = ""
+
+	repo.events[i] = &redacted
+	return nil
+}
+
+func (repo *eventRepository) index(id ulid.ULID) (int, bool) {
+	i := sort.Search(len(repo.events), func(i int) bool {
+		return repo.events[i].ID.Compare(id) >= 0
+	})
+
+	if i < len(repo.events) && repo.events[i].ID == id {
+		return i, true
+	}
+
+	return 0, false
+}
+
 func (repo *eventRepository) Close() error {
 	repo.Lock()
 	defer repo.Unlock()
@@ -116,7 +116,7 @@ func (c *payloadCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWr
 		return enc.Encode(raw)
 
 	case []byte:
-		if payload.Type != events.Bytes {
+		if !payload.Type.IsBinary() {
 			return errors.New("invalid subtype")
 		}
 
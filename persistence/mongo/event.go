@@ -2,7 +2,7 @@ package mongo
 
 import (
 	"context"
-	"sync"
+	"errors"
 	"time"
 
 	"github.com/oklog/ulid/v2"
@@ -13,10 +13,12 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/buffer"
 )
 
 type EventRepository interface {
 	events.Repository
+	events.Buffered
 	DropDatabase(name string) error
 }
 
@@ -24,12 +26,17 @@ type eventRepository struct {
 	log    *zap.Logger
 	cfg    *Config
 	db     *mongo.Database
-	docs   []any
+	docs   *buffer.Buffer[*Event]
+	stats  *events.DriverMetrics
 	ctx    context.Context
 	cancel context.CancelFunc
-	sync.Mutex
+	done   chan struct{} // closed once the pending documents are written
 }
 
+// NewEventRepository stores the events in time series collections. Deleting
+// from them by any field, as Retain, Delete and DeleteRange do, requires
+// MongoDB 7.0, and updating them, as Redact does, MongoDB 8.0; older servers
+// reject these operations.
 func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -38,14 +45,23 @@ func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
 		return nil, err
 	}
 
+	// each namespace has its own collection, e.g. events_acme
+	namespace := events.DefaultNamespace
+	if !events.IsDefaultNamespace(cfg.Namespace) {
+		namespace = cfg.Namespace
+		conf.Collection += "_" + cfg.Namespace
+	}
+
 	repo := &eventRepository{
 		log: zap.L().With(
 			zap.String("persistence", "mongo"),
+			zap.String("namespace", namespace),
 		),
 		cfg:    conf,
-		docs:   make([]any, 0),
+		stats:  cfg.Metrics.Driver("mongo", namespace),
 		ctx:    ctx,
 		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 
 	ulidCodec := NewULIDCodec()
@@ -70,90 +86,207 @@ func NewEventRepository(cfg events.Persistence) (events.Repository, error) {
 
 	db := client.Database(conf.Database)
 
-	tso := options.TimeSeries().SetTimeField("_time")
-	opts := options.CreateCollection().SetTimeSeriesOptions(tso)
-
-	if err := db.CreateCollection(ctx, conf.Collection, opts); err != nil {
-		cmdErr, ok := err.(mongo.CommandError)
-		if !ok || cmdErr.Code != 48 {
-			return nil, err
+	// a retention policy of all topics is enforced by the server
+	var expireAfter time.Duration
+	for _, policy := range cfg.Retention {
+		if policy.AllTopics() && policy.MaxAge > 0 {
+			expireAfter = policy.MaxAge
+			break
 		}
 	}
 
+	if err := createCollection(ctx, db, conf.Collection, expireAfter); err != nil {
+		return nil, err
+	}
+
+	// reserved topics, e.g. the audit trail, are kept in a collection of their
+	// own, which the server never expires
+	if err := createCollection(ctx, db, reservedCollection(conf.Collection), 0); err != nil {
+		return nil, err
+	}
+
+	docs, err := buffer.New[*Event](cfg.Buffer, "mongo-"+namespace, bsonCodec{}, repo.stats.PendingEvents)
+	if err != nil {
+		return nil, err
+	}
+
 	repo.db = db
+	repo.docs = docs
 
 	go repo.batchWriteHandler(repo.ctx)
 
 	return repo, nil
 }
 
+// createCollection creates the time series collection, or updates the expiry
+// of an existing one; it never expires without a duration.
+func createCollection(ctx context.Context, db *mongo.Database, name string, expireAfter time.Duration) error {
+	tso := options.TimeSeries().SetTimeField("_time")
+	opts := options.CreateCollection().SetTimeSeriesOptions(tso)
+
+	if expireAfter > 0 {
+		opts.SetExpireAfterSeconds(int64(expireAfter.Seconds()))
+	}
+
+	err := db.CreateCollection(ctx, name, opts)
+	if err == nil {
+		return nil
+	}
+
+	// NamespaceExists
+	cmdErr, ok := err.(mongo.CommandError)
+	if !ok || cmdErr.Code != 48 {
+		return err
+	}
+
+	var expiry any = "off"
+	if expireAfter > 0 {
+		expiry = int64(expireAfter.Seconds())
+	}
+
+	cmd := bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "expireAfterSeconds", Value: expiry},
+	}
+
+	return db.RunCommand(ctx, cmd).Err()
+}
+
+// reservedCollection returns the collection of the reserved topics; the dot
+// keeps it apart from the collections of namespaces.
+func reservedCollection(collection string) string {
+	return collection + ".reserved"
+}
+
+// collection returns the collection of the topic.
+func (repo *eventRepository) collection(topic string) *mongo.Collection {
+	if events.IsReservedTopic(topic) {
+		return repo.db.Collection(reservedCollection(repo.cfg.Collection))
+	}
+
+	return repo.db.Collection(repo.cfg.Collection)
+}
+
+// collections returns the collections of all topics.
+func (repo *eventRepository) collections() []*mongo.Collection {
+	return []*mongo.Collection{
+		repo.db.Collection(repo.cfg.Collection),
+		repo.db.Collection(reservedCollection(repo.cfg.Collection)),
+	}
+}
+
 func (repo *eventRepository) batchWriteHandler(ctx context.Context) {
 	log := repo.log.With(
 		zap.String("action", "batch_write"),
 	)
 
-	coll := repo.db.Collection("events")
-
 	ticker := time.NewTicker(repo.cfg.Duration)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			repo.Lock()
-			if size := len(repo.docs); size > 0 {
-				log := log.With(zap.Int("points", size))
-
-				ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-				_, err := coll.InsertMany(ctx, repo.docs)
-				if err != nil {
-					log.Error(err.Error())
-				} else {
-					log.Info("points written")
-				}
-
-				cancel()
-				repo.docs = make([]any, 0)
-			}
-			repo.Unlock()
+			repo.flush(context.Background(), log)
+			close(repo.done)
 
 			log.Info("done")
 			return
 
 		case <-ticker.C:
-			repo.Lock()
-			if size := len(repo.docs); size > 0 {
-				log := log.With(zap.Int("points", size))
+			repo.flush(ctx, log)
 
-				ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-				_, err := coll.InsertMany(ctx, repo.docs)
-				if err != nil {
-					log.Error(err.Error())
-				} else {
-					log.Info("points written")
-				}
+		case <-repo.docs.Flush():
+			repo.flush(ctx, log)
+		}
+	}
+}
 
-				cancel()
-				repo.docs = make([]any, 0)
+// flush writes the pending documents. Documents failing to be written are
+// retried by the next flush, unless the server rejected them.
+func (repo *eventRepository) flush(ctx context.Context, log *zap.Logger) error {
+	begin := time.Now()
+
+	n, err := repo.docs.Drain(func(docs []*Event) error {
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		batches := make(map[string][]any)
+		for _, doc := range docs {
+			name := repo.collection(doc.Topic).Name()
+			batches[name] = append(batches[name], doc)
+		}
+
+		for name, batch := range batches {
+			coll := repo.db.Collection(name)
+
+			// unordered, so a rejected document does not stop the others
+			_, err := coll.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+
+			var bwe mongo.BulkWriteException
+			if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+				return buffer.Permanent(err)
+			}
+
+			if err != nil {
+				return err
 			}
-			repo.Unlock()
 		}
+
+		return nil
+	})
+
+	if n == 0 && err == nil {
+		return nil
+	}
+
+	repo.stats.FlushDuration.Observe(time.Since(begin).Seconds())
+
+	log = log.With(zap.Int("points", n))
+
+	if err != nil {
+		repo.stats.FlushFailures.Add(1)
+		log.Error(err.Error(), zap.Int("pending", repo.docs.Len()))
+		return err
 	}
+
+	log.Info("points written")
+	return nil
 }
 
+// Flush writes the pending documents now.
+func (repo *eventRepository) Flush(ctx context.Context) error {
+	log := repo.log.With(
+		zap.String("action", "flush"),
+	)
+
+	return repo.flush(ctx, log)
+}
+
+// Store buffers the event, which may fail with events.ErrBackpressure once
+// the buffer is full.
 func (repo *eventRepository) Store(e *events.Event) error {
-	repo.Lock()
+	return repo.docs.Push(NewEvent(e))
+}
 
-	doc := NewEvent(e)
-	repo.docs = append(repo.docs, doc)
+func (repo *eventRepository) Buffered() int {
+	return repo.docs.Len()
+}
 
-	repo.Unlock()
-	return nil
+// Health pings the primary, and checks that the pending documents are
+// written in time.
+func (repo *eventRepository) Health(ctx context.Context) error {
+	if err := repo.db.Client().Ping(ctx, readpref.Primary()); err != nil {
+		return err
+	}
+
+	return repo.docs.Check()
 }
 
 func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (events.Iterator, error) {
 	var (
 		prefetchSize = 10
 		ch           = make(chan *events.Event, prefetchSize*2) // buffer + prefetch
-		errCh        = make(chan error)
+		errCh        = make(chan error, 1)                      // never blocks the poll
 	)
 
 	ctx, cancel := context.WithCancelCause(ctx)
@@ -161,13 +294,12 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 		var last ulid.ULID
 		last.SetTime(ulid.Timestamp(since))
 
-		coll := repo.db.Collection(repo.cfg.Collection)
-
 		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-ctx.Done():
-				errCh <- nil
 				return
 
 			case <-ticker.C:
@@ -186,26 +318,32 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 					},
 				}
 
-				cursor, err := coll.Find(ctx, filter)
+				cursors, err := repo.find(ctx, filter)
 				if err == nil {
-					for cursor.Next(ctx) {
-						var result *Event
-						err = cursor.Decode(&result)
+					var e *events.Event
+					for e, err = cursors.next(ctx); e != nil; e, err = cursors.next(ctx) {
+						// a closed iterator is not fetched from anymore
+						select {
+						case ch <- e:
+						case <-ctx.Done():
+							err = ctx.Err()
+						}
+
 						if err != nil {
 							break
-						} else {
-							e := result.Event()
-
-							ch <- e
-							last = e.ID
 						}
+
+						last = e.ID
 					}
 
-					cursor.Close(ctx)
+					cursors.close()
 				}
 
 				if err != nil {
-					errCh <- err
+					if ctx.Err() == nil {
+						errCh <- err
+					}
+
 					return
 				}
 			}
@@ -226,11 +364,312 @@ func (repo *eventRepository) Iterator(ctx context.Context, since time.Time) (eve
 	return it, nil
 }
 
+// find returns the matching documents of all collections, in the order of
+// their IDs.
+func (repo *eventRepository) find(ctx context.Context, filter bson.D) (*cursors, error) {
+	opts := options.Find().SetSort(bson.D{
+		{Key: "_time", Value: 1},
+		{Key: "id", Value: 1},
+	})
+
+	cs := &cursors{}
+	for _, coll := range repo.collections() {
+		cursor, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			cs.close()
+			return nil, err
+		}
+
+		cs.cursors = append(cs.cursors, cursor)
+		cs.heads = append(cs.heads, nil)
+	}
+
+	return cs, nil
+}
+
+// cursors merges the cursors of the collections, each sorted by ID.
+type cursors struct {
+	cursors []*mongo.Cursor
+	heads   []*events.Event // next event of each cursor, nil once read
+}
+
+// next returns the event with the lowest ID of all cursors, or nil once they
+// are exhausted.
+func (cs *cursors) next(ctx context.Context) (*events.Event, error) {
+	next := -1
+	for i, cursor := range cs.cursors {
+		if cs.heads[i] == nil && cursor.Next(ctx) {
+			var doc *Event
+			if err := cursor.Decode(&doc); err != nil {
+				return nil, err
+			}
+
+			cs.heads[i] = doc.Event()
+		}
+
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+
+		head := cs.heads[i]
+		if head != nil && (next < 0 || head.ID.Compare(cs.heads[next].ID) < 0) {
+			next = i
+		}
+	}
+
+	if next < 0 {
+		return nil, nil
+	}
+
+	e := cs.heads[next]
+	cs.heads[next] = nil
+
+	return e, nil
+}
+
+func (cs *cursors) close() {
+	for _, cursor := range cs.cursors {
+		cursor.Close(context.Background())
+	}
+}
+
+// Retain requires MongoDB 7.0, like DeleteRange.
+func (repo *eventRepository) Retain(policy events.RetentionPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.ctx, 30*time.Second)
+	defer cancel()
+
+	deleted := 0
+	for _, coll := range repo.collections() {
+		n, err := repo.retain(ctx, coll, policy)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+func (repo *eventRepository) retain(ctx context.Context, coll *mongo.Collection, policy events.RetentionPolicy) (int, error) {
+	values, err := coll.Distinct(ctx, "topic", bson.D{})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, value := range values {
+		topic, ok := value.(string)
+		if !ok || !policy.Match(topic) {
+			continue
+		}
+
+		cutoff := time.Time{}
+		if policy.MaxAge > 0 {
+			cutoff = time.Now().Add(-policy.MaxAge)
+		}
+
+		if policy.MaxCount > 0 {
+			// _time of the oldest event to keep
+			opts := options.FindOne().
+				SetSort(bson.D{{Key: "_time", Value: -1}}).
+				SetSkip(int64(policy.MaxCount - 1))
+
+			var doc *Event
+			err := coll.FindOne(ctx, bson.D{{Key: "topic", Value: topic}}, opts).Decode(&doc)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return deleted, err
+			}
+
+			if doc != nil && doc.Time.After(cutoff) {
+				cutoff = doc.Time
+			}
+		}
+
+		if cutoff.IsZero() {
+			continue
+		}
+
+		filter := bson.D{
+			{Key: "topic", Value: topic},
+			{Key: "_time", Value: bson.D{{Key: "$lt", Value: cutoff}}},
+		}
+
+		result, err := coll.DeleteMany(ctx, filter)
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += int(result.DeletedCount)
+	}
+
+	return deleted, nil
+}
+
+// Delete requires MongoDB 7.0, which deletes single documents of time series
+// collections.
+func (repo *eventRepository) Delete(id ulid.ULID) error {
+	n, err := repo.deletePending(func(doc *Event) bool { return doc.ID == id })
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(repo.ctx, 10*time.Second)
+	defer cancel()
+
+	for _, coll := range repo.collections() {
+		result, err := coll.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+		if err != nil {
+			return err
+		}
+
+		if result.DeletedCount > 0 {
+			return nil
+		}
+	}
+
+	return events.ErrEventNotFound
+}
+
+// DeleteRange requires MongoDB 7.0, which deletes documents of time series
+// collections by fields other than the metaField.
+func (repo *eventRepository) DeleteRange(topic string, from time.Time, to time.Time) (int, error) {
+	lower, upper := time.UnixMilli(from.UnixMilli()), time.UnixMilli(to.UnixMilli())
+
+	deleted, err := repo.deletePending(func(doc *Event) bool {
+		return !doc.Time.Before(lower) && doc.Time.Before(upper) &&
+			events.MatchTopic(topic, doc.Topic)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(repo.ctx, 30*time.Second)
+	defer cancel()
+
+	coll := repo.collection(topic)
+
+	timeRange := bson.D{
+		{Key: "$gte", Value: lower},
+		{Key: "$lt", Value: upper},
+	}
+
+	values, err := coll.Distinct(ctx, "topic", bson.D{{Key: "_time", Value: timeRange}})
+	if err != nil {
+		return deleted, err
+	}
+
+	topics := make([]string, 0)
+	for _, value := range values {
+		if t, ok := value.(string); ok && events.MatchTopic(topic, t) {
+			topics = append(topics, t)
+		}
+	}
+
+	if len(topics) == 0 {
+		return deleted, nil
+	}
+
+	filter := bson.D{
+		{Key: "topic", Value: bson.D{{Key: "$in", Value: topics}}},
+		{Key: "_time", Value: timeRange},
+	}
+
+	result, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return deleted, err
+	}
+
+	return deleted + int(result.DeletedCount), nil
+}
+
+// Redact requires MongoDB 8.0, which updates single documents of time series
+// collections.
+func (repo *eventRepository) Redact(id ulid.ULID, reason string) error {
+	payload := events.NewErasedPayload(reason)
+
+	redacted := false
+	err := repo.docs.Update(func(docs []*Event) []*Event {
+		for _, doc := range docs {
+			if doc.ID == id {
+				doc.Payload = payload
+				doc.KeyID = ""
+				doc.Type = 0
+				doc.TypeURL = ""
+
+				redacted = true
+			}
+		}
+
+		return docs
+	})
+
+	if err != nil || redacted {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(repo.ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "payload", Value: payload}}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "key_id", Value: ""},
+			{Key: "type", Value: ""},
+			{Key: "type_url", Value: ""},
+		}},
+	}
+
+	for _, coll := range repo.collections() {
+		result, err := coll.UpdateOne(ctx, bson.D{{Key: "id", Value: id}}, update)
+		if err != nil {
+			return err
+		}
+
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+
+	return events.ErrEventNotFound
+}
+
+// deletePending drops the matching documents not written yet, and returns
+// their number.
+func (repo *eventRepository) deletePending(match func(doc *Event) bool) (int, error) {
+	deleted := 0
+	err := repo.docs.Update(func(docs []*Event) []*Event {
+		kept := make([]*Event, 0, len(docs))
+		for _, doc := range docs {
+			if match(doc) {
+				deleted++
+				continue
+			}
+
+			kept = append(kept, doc)
+		}
+
+		return kept
+	})
+
+	return deleted, err
+}
+
+// Close writes the pending documents before disconnecting.
 func (repo *eventRepository) Close() error {
+	repo.cancel()
+	<-repo.done
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return repo.db.Client().Disconnect(ctx)
+	return errors.Join(
+		repo.docs.Close(),
+		repo.db.Client().Disconnect(ctx),
+	)
 }
 
 func (repo *eventRepository) DropDatabase(name string) error {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/oklog/ulid/v2"
+	"go.mongodb.org/mongo-driver/bson"
 
 	"github.com/mirror520/events"
 )
@@ -65,24 +66,74 @@ type Event struct {
 	ID      ulid.ULID      `bson:"id"`
 	Topic   string         `bson:"topic"`
 	Payload events.Payload `bson:"payload"`
+	Version int            `bson:"version,omitempty"`
+	KeyID   string         `bson:"key_id,omitempty"`
+	Subject string         `bson:"subject,omitempty"`
+	Key     string         `bson:"key,omitempty"`
+
+	TraceParent string `bson:"traceparent,omitempty"`
+
+	// content-typed binary payloads are stored as generic binary data
+	Type    events.DataType `bson:"type,omitempty"`
+	TypeURL string          `bson:"type_url,omitempty"`
 }
 
 func NewEvent(e *events.Event) *Event {
 	ms := int64(e.ID.Time())
 	ts := time.UnixMilli(ms)
 
-	return &Event{
+	doc := &Event{
 		Time:    ts,
 		ID:      e.ID,
 		Topic:   e.Topic,
 		Payload: e.Payload,
+		Version: e.Version,
+		KeyID:   e.KeyID,
+		Subject: e.Subject,
+		Key:     e.Key,
+
+		TraceParent: e.TraceParent,
+	}
+
+	if e.Payload.Type.IsBinary() && e.Payload.Type != events.Bytes {
+		doc.Type = e.Payload.Type
+		doc.TypeURL = e.Payload.TypeURL
 	}
+
+	return doc
 }
 
 func (e *Event) Event() *events.Event {
+	payload := e.Payload
+	if bs, ok := payload.Bytes(); ok && e.Type.IsBinary() {
+		payload.SetTypedBytes(bs, e.Type, e.TypeURL)
+	}
+
 	return &events.Event{
 		ID:      e.ID,
 		Topic:   e.Topic,
-		Payload: e.Payload,
+		Payload: payload,
+		Version: e.Version,
+		KeyID:   e.KeyID,
+		Subject: e.Subject,
+		Key:     e.Key,
+
+		TraceParent: e.TraceParent,
 	}
 }
+
+// bsonCodec encodes the documents spilled to disk.
+type bsonCodec struct{}
+
+func (bsonCodec) Marshal(doc *Event) ([]byte, error) {
+	return bson.Marshal(doc)
+}
+
+func (bsonCodec) Unmarshal(data []byte) (*Event, error) {
+	var doc *Event
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
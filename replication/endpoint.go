@@ -0,0 +1,36 @@
+package replication
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func StatusEndpoint(r *Replicator) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		return r.Status(), nil
+	}
+}
+
+// PromoteEndpoint stops the replication, so the replica accepts writes and
+// can take over from a lost primary.
+func PromoteEndpoint(r *Replicator) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		r.Down()
+		return r.Status(), nil
+	}
+}
+
+// ReadOnlyMiddleware rejects writes while the replicator is running, since
+// they would never reach the primary.
+func ReadOnlyMiddleware(r *Replicator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			if r.Running() {
+				return nil, ErrReadOnly
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
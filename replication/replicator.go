@@ -0,0 +1,286 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"go.uber.org/zap"
+
+	"github.com/mirror520/events"
+)
+
+var (
+	ErrReadOnly = errors.New("read-only replica")
+)
+
+// Primary is the iterator API of the instance followed by a replica.
+type Primary interface {
+	NewIterator(ctx context.Context, topic string, since time.Time) (string, error)
+	FetchFromIterator(ctx context.Context, id string, batch int) ([]*events.Event, error)
+	CloseIterator(ctx context.Context, id string) error
+}
+
+type Status struct {
+	Primary  string    `json:"primary"`
+	Running  bool      `json:"running"`
+	Position ulid.ULID `json:"position"`
+	LastSync time.Time `json:"last_sync,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Replicator follows the events of a primary and stores them in the local
+// repository with identical IDs. The position, i.e. the ID of the last
+// replicated event, is persisted after every batch, so replication resumes
+// where it stopped.
+type Replicator struct {
+	log     *zap.Logger
+	cfg     events.Replication
+	primary Primary
+	events  events.Repository
+
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+	sync.RWMutex
+}
+
+func NewReplicator(primary Primary, repo events.Repository, cfg events.Replication) (*Replicator, error) {
+	if cfg.Batch <= 0 {
+		cfg.Batch = 100
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+
+	r := &Replicator{
+		log: zap.L().With(
+			zap.String("service", "replication"),
+			zap.String("primary", cfg.Primary),
+		),
+		cfg:     cfg,
+		primary: primary,
+		events:  repo,
+	}
+
+	r.status.Primary = cfg.Primary
+
+	if cfg.Position != "" {
+		position, err := readPosition(cfg.Position)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		r.status.Position = position
+	}
+
+	return r, nil
+}
+
+func (r *Replicator) Up() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.status.Running {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	r.status.Running = true
+
+	go r.replicationHandler(ctx, r.done)
+
+	r.log.Info("done", zap.String("action", "up"))
+}
+
+// Down stops the replication, e.g. to promote the replica once the primary
+// is lost.
+func (r *Replicator) Down() {
+	r.Lock()
+	if !r.status.Running {
+		r.Unlock()
+		return
+	}
+
+	r.cancel()
+	done := r.done
+	r.status.Running = false
+	r.Unlock()
+
+	<-done
+	r.log.Info("done", zap.String("action", "down"))
+}
+
+func (r *Replicator) Running() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.status.Running
+}
+
+func (r *Replicator) Status() Status {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.status
+}
+
+func (r *Replicator) replicationHandler(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	log := r.log.With(
+		zap.String("handler", "replication"),
+	)
+
+	var id string
+	defer func() {
+		if id != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			r.primary.CloseIterator(ctx, id)
+			cancel()
+		}
+	}()
+
+	backoff := r.cfg.Interval
+	for {
+		if id == "" {
+			position := r.Status().Position
+
+			var err error
+			id, err = r.primary.NewIterator(ctx, r.cfg.Topic, ulid.Time(position.Time()))
+			if err != nil {
+				id = ""
+				r.fail(log, err)
+
+				if !sleep(ctx, backoff) {
+					return
+				}
+
+				backoff = min(backoff*2, time.Minute)
+				continue
+			}
+		}
+
+		n, err := r.replicate(ctx, id)
+		switch {
+		case err == nil:
+			backoff = r.cfg.Interval
+			if n > 0 {
+				continue
+			}
+
+		case errors.Is(err, events.ErrEventEmpty), errors.Is(err, events.ErrTimeout):
+			// caught up with the primary
+
+		case ctx.Err() != nil:
+			return
+
+		default:
+			// the iterator is created again, e.g. once the primary restarted
+			id = ""
+			r.fail(log, err)
+
+			if !sleep(ctx, backoff) {
+				return
+			}
+
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+
+		if !sleep(ctx, r.cfg.Interval) {
+			return
+		}
+	}
+}
+
+// replicate stores a batch of events from the primary, and returns the number
+// of stored events. Events up to the position are skipped, since iterators
+// only start from the millisecond of the position.
+func (r *Replicator) replicate(ctx context.Context, id string) (int, error) {
+	es, err := r.primary.FetchFromIterator(ctx, id, r.cfg.Batch)
+	if err != nil {
+		return 0, err
+	}
+
+	position := r.Status().Position
+
+	var (
+		n        int
+		storeErr error
+	)
+
+	for _, e := range es {
+		if e.ID.Compare(position) <= 0 {
+			continue
+		}
+
+		if storeErr = r.events.Store(e); storeErr != nil {
+			break
+		}
+
+		position = e.ID
+		n++
+	}
+
+	// the position of the stored events is kept even if the batch failed
+	if n > 0 && r.cfg.Position != "" {
+		if err := writePosition(r.cfg.Position, position); err != nil {
+			return n, err
+		}
+	}
+
+	r.Lock()
+	r.status.Position = position
+	if storeErr == nil {
+		r.status.LastSync = time.Now()
+		r.status.Error = ""
+	}
+	r.Unlock()
+
+	return n, storeErr
+}
+
+func (r *Replicator) fail(log *zap.Logger, err error) {
+	log.Error(err.Error())
+
+	r.Lock()
+	r.status.Error = err.Error()
+	r.Unlock()
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+
+	case <-time.After(d):
+		return true
+	}
+}
+
+func readPosition(name string) (ulid.ULID, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+
+	return ulid.ParseStrict(string(data))
+}
+
+// writePosition replaces the file atomically, so a crash never leaves it
+// empty.
+func writePosition(name string, id ulid.ULID) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id.String()), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,120 @@
+package replication_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/persistence/inmem"
+	"github.com/mirror520/events/replication"
+	"github.com/mirror520/events/transport/http"
+)
+
+func newPrimary(svc events.Service) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	apiV1 := r.Group("/v1")
+	apiV1.POST("/events/iterators", http.NewIteratorHandler(events.NewIteratorEndpoint(svc)))
+	apiV1.GET("/events/iterators/:id", http.FetchFromIteratorHandler(events.FetchFromIterator(svc)))
+	apiV1.DELETE("/events/iterators/:id", http.CloseIteratorHandler(events.CloseIterator(svc)))
+
+	return httptest.NewServer(r)
+}
+
+func fetchAll(repo events.Repository) []*events.Event {
+	it, err := repo.Iterator(context.TODO(), time.Time{})
+	if err != nil {
+		return nil
+	}
+	defer it.Close(nil)
+
+	es, _ := it.Fetch(100)
+	return es
+}
+
+func TestReplicator(t *testing.T) {
+	assert := assert.New(t)
+
+	primaryRepo, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+	defer primaryRepo.Close()
+
+	svc := events.NewService(primaryRepo)
+	svc.Up()
+	defer svc.Down()
+
+	server := newPrimary(svc)
+	defer server.Close()
+
+	var p events.Payload
+	p.SetTypedBytes([]byte{0x08, 0x96, 0x01}, events.Protobuf, "type.googleapis.com/test.Message")
+
+	svc.Store(events.NewEvent("hello.world", events.NewPayload("Hello World")))
+	svc.Store(events.NewEvent("hello.world", events.NewPayloadFromJSON([]byte(`{"msg":"Hello"}`))))
+	svc.Store(events.NewEvent("hello.proto", p))
+
+	client, err := http.NewClient(server.URL, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	replicaRepo, _ := inmem.NewEventRepository(events.Persistence{Driver: events.InMem})
+	defer replicaRepo.Close()
+
+	cfg := events.Replication{
+		Primary:  server.URL,
+		Interval: 50 * time.Millisecond,
+		Position: filepath.Join(t.TempDir(), "replication.pos"),
+	}
+
+	replicator, err := replication.NewReplicator(client, replicaRepo, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	replicator.Up()
+
+	assert.Eventually(func() bool {
+		return len(fetchAll(replicaRepo)) == 3
+	}, 3*time.Second, 50*time.Millisecond)
+
+	replicator.Down()
+
+	expected := fetchAll(primaryRepo)
+	actual := fetchAll(replicaRepo)
+	if assert.Len(actual, 3) {
+		for i, e := range expected {
+			assert.Equal(e.ID, actual[i].ID)
+			assert.Equal(e.Topic, actual[i].Topic)
+			assert.Equal(e.Payload, actual[i].Payload)
+		}
+	}
+
+	assert.Equal(expected[2].ID, replicator.Status().Position)
+
+	// a new replicator resumes from the persisted position
+	svc.Store(events.NewEvent("hello.world", events.NewPayload("Hello Again")))
+
+	replicator, err = replication.NewReplicator(client, replicaRepo, cfg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal(expected[2].ID, replicator.Status().Position)
+
+	replicator.Up()
+	defer replicator.Down()
+
+	assert.Eventually(func() bool {
+		return len(fetchAll(replicaRepo)) == 4
+	}, 3*time.Second, 50*time.Millisecond)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(fetchAll(replicaRepo), 4)
+}
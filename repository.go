@@ -4,18 +4,108 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/oklog/ulid/v2"
 )
 
 var (
-	ErrTimeout = errors.New("timeout")
+	ErrTimeout       = errors.New("timeout")
+	ErrEventEmpty    = errors.New("event empty")
+	ErrNotSupported  = errors.New("not supported")
+	ErrEventNotFound = errors.New("event not found")
+	ErrBackpressure  = errors.New("backpressure")
 )
 
+// HealthChecker is implemented by repositories able to check their storage,
+// e.g. that the database is open or that the server responds.
+type HealthChecker interface {
+	Health(ctx context.Context) error
+}
+
+// Buffered is implemented by repositories buffering the stored events before
+// writing them in batches. It returns the number of events not written yet.
+type Buffered interface {
+	Buffered() int
+}
+
+// Flusher is implemented by buffering repositories. Flush writes the
+// buffered events, failing unless every one is written, e.g. before
+// recording the position of stored events.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
 type Repository interface {
 	Store(e *Event) error
 	Iterator(ctx context.Context, since time.Time) (Iterator, error)
 	Close() error
 }
 
+type RetentionPolicy struct {
+	Topic    string        `yaml:"topic"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	MaxCount int           `yaml:"max_count"`
+}
+
+// AllTopics reports whether the policy applies to every topic, except the
+// reserved ones.
+func (p RetentionPolicy) AllTopics() bool {
+	return p.Topic == "" || p.Topic == "**"
+}
+
+func (p RetentionPolicy) Match(topic string) bool {
+	if p.AllTopics() {
+		return !IsReservedTopic(topic)
+	}
+
+	return MatchTopic(p.Topic, topic)
+}
+
+// Retainer is implemented by repositories able to delete the events which
+// exceed a retention policy. It returns the number of deleted events.
+type Retainer interface {
+	Retain(policy RetentionPolicy) (int, error)
+}
+
+// CompactionPolicy marks the matching topics as compacted: only the latest
+// event of each key is kept. A tombstone, i.e. a keyed event without payload,
+// deletes its key once older events have been compacted.
+type CompactionPolicy struct {
+	Topic string `yaml:"topic"`
+
+	// DeleteRetention is how long tombstones are kept once they are the latest
+	// event of their key; zero keeps them forever.
+	DeleteRetention time.Duration `yaml:"delete_retention"`
+}
+
+func (p CompactionPolicy) Match(topic string) bool {
+	return MatchTopic(p.Topic, topic)
+}
+
+// Compactor is implemented by repositories able to compact the events of
+// matching topics, keeping only the latest event per key. Events without a
+// key are always kept. It returns the number of deleted events.
+type Compactor interface {
+	Compact(policy CompactionPolicy) (int, error)
+}
+
+// Shredder is implemented by repositories able to crypto-shred the events of
+// a data subject, by destroying the keys their payloads are encrypted with.
+type Shredder interface {
+	ShredSubject(subject string) error
+}
+
+// Eraser is implemented by repositories able to delete and redact individual
+// events. DeleteRange deletes the events of matching topics from the given
+// time, inclusive, to the given time, exclusive, and returns their number.
+// Redact replaces the payload of an event with an erased marker, keeping its
+// ID and topic.
+type Eraser interface {
+	Delete(id ulid.ULID) error
+	DeleteRange(topic string, from time.Time, to time.Time) (int, error)
+	Redact(id ulid.ULID, reason string) error
+}
+
 type Iterator interface {
 	ID() string
 	Fetch(batch int) ([]*Event, error)
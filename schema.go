@@ -0,0 +1,195 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrSchemaNotFound = errors.New("schema not found")
+)
+
+type Violation struct {
+	Location string `json:"location"`
+	Keyword  string `json:"keyword"`
+	Message  string `json:"message"`
+}
+
+type ValidationError struct {
+	Topic      string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = fmt.Sprintf("%s: %s", v.Location, v.Message)
+	}
+
+	return fmt.Sprintf("payload of topic '%s' does not conform to schema: %s",
+		e.Topic, strings.Join(msgs, "; "))
+}
+
+type topicSchema struct {
+	pattern string
+	schema  *jsonschema.Schema
+}
+
+// SchemaRegistry holds JSON Schemas keyed by topic pattern. The first
+// registered pattern that matches a topic is used to validate its payloads.
+type SchemaRegistry struct {
+	schemas []*topicSchema
+	sync.RWMutex
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		schemas: make([]*topicSchema, 0),
+	}
+}
+
+func (r *SchemaRegistry) Register(pattern string, schema []byte) error {
+	url := "schema://" + pattern
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return err
+	}
+
+	s, err := c.Compile(url)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, ts := range r.schemas {
+		if ts.pattern == pattern {
+			ts.schema = s
+			return nil
+		}
+	}
+
+	r.schemas = append(r.schemas, &topicSchema{pattern, s})
+	return nil
+}
+
+func (r *SchemaRegistry) Schema(topic string) (*jsonschema.Schema, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, ts := range r.schemas {
+		if MatchTopic(ts.pattern, topic) {
+			return ts.schema, nil
+		}
+	}
+
+	return nil, ErrSchemaNotFound
+}
+
+// Validate checks the payload against the schema registered for the topic.
+// Topics without a schema are always valid.
+func (r *SchemaRegistry) Validate(topic string, payload Payload) error {
+	schema, err := r.Schema(topic)
+	if err != nil {
+		if errors.Is(err, ErrSchemaNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	var data []byte
+	switch payload.Type {
+	case JSON:
+		raw, ok := payload.JSON()
+		if !ok {
+			return ErrInvalidType
+		}
+
+		data = raw
+
+	case Any:
+		raw, err := json.Marshal(payload.Data)
+		if err != nil {
+			return err
+		}
+
+		data = raw
+
+	default:
+		return &ValidationError{
+			Topic: topic,
+			Violations: []Violation{
+				{Message: "payload is not json"},
+			},
+		}
+	}
+
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	err = schema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+
+	return &ValidationError{
+		Topic:      topic,
+		Violations: violations(verr),
+	}
+}
+
+func violations(err *jsonschema.ValidationError) []Violation {
+	if len(err.Causes) == 0 {
+		return []Violation{
+			{
+				Location: "#" + err.InstanceLocation,
+				Keyword:  err.KeywordLocation,
+				Message:  err.Message,
+			},
+		}
+	}
+
+	vs := make([]Violation, 0)
+	for _, cause := range err.Causes {
+		vs = append(vs, violations(cause)...)
+	}
+
+	return vs
+}
+
+func ValidationMiddleware(svc Service) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			req, ok := request.(StoreRequest)
+			if !ok {
+				return nil, errors.New("invalid request")
+			}
+
+			if err := svc.ValidatePayload(req.Topic, req.Payload); err != nil {
+				return nil, err
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaRegistryValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	schema := []byte(`{
+		"type": "object",
+		"properties": {
+			"temperature": { "type": "number" }
+		},
+		"required": ["temperature"]
+	}`)
+
+	registry := NewSchemaRegistry()
+	if err := registry.Register("sensors/*", schema); err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	// valid payload
+	{
+		payload := NewPayloadFromJSON(json.RawMessage(`{"temperature":25.3}`))
+		err := registry.Validate("sensors/1", payload)
+		assert.NoError(err)
+	}
+
+	// invalid payload
+	{
+		payload := NewPayloadFromJSON(json.RawMessage(`{"temperature":"hot"}`))
+		err := registry.Validate("sensors/1", payload)
+
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			assert.Fail("invalid type")
+			return
+		}
+
+		assert.Len(verr.Violations, 1)
+		assert.Equal("#/temperature", verr.Violations[0].Location)
+	}
+
+	// unregistered topic
+	{
+		payload := NewPayload("Hello World")
+		err := registry.Validate("hello/world", payload)
+		assert.NoError(err)
+	}
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := NewService(nil)
+	svc.RegisterSchema("hello/*", []byte(`{"type": "string"}`))
+
+	ack := make(chan any)
+
+	endpoint := debugMiddleware(ack)
+	endpoint = ValidationMiddleware(svc)(endpoint)
+
+	req := StoreRequest{
+		Topic:   "hello/world",
+		Payload: NewPayload(3.14),
+	}
+
+	_, err := endpoint(context.Background(), req)
+	assert.IsType(&ValidationError{}, err)
+
+	req.Payload = NewPayload("Hello World")
+	endpoint(context.Background(), req)
+
+	request := <-ack
+	assert.Equal(req, request)
+}
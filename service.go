@@ -3,9 +3,14 @@ package events
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	"github.com/oklog/ulid/v2"
 	"go.uber.org/zap"
 )
@@ -14,56 +19,289 @@ var (
 	ErrEmptyPayload     = errors.New("empty payload")
 	ErrIteratorNotFound = errors.New("iterator not found")
 	ErrInvalidType      = errors.New("invalid type")
+	ErrUnhealthy        = errors.New("unhealthy")
+	ErrIteratorExpired  = errors.New("iterator expired")
 )
 
+// maxUpcastAttempts bounds how often an iterator fetches an event whose upcast
+// fails, before skipping it.
+const maxUpcastAttempts = 3
+
 type Service interface {
 	Up()
 	Down()
-	Store(topic string, payload Payload, ids ...ulid.ULID) error
-	NewIterator(topic string, since time.Time) (string, error)
+	Store(e *Event) error
+	NewIterator(topic string, since time.Time, opts ...IteratorOption) (string, error)
 	Iterator(id string) (Iterator, error)
+	Iterators(owner string) []*IteratorInfo
+
+	// Iterator, of the given owner; an empty owner skips the check
+	FetchFromIterator(batch int, id string, owner string) ([]*Event, error)
+	CloseIterator(id string, owner string) error
+
+	// Schema
+	RegisterSchema(topic string, schema []byte) error
+	ValidatePayload(topic string, payload Payload) error
+	RegisterUpcaster(topic string, version int, up Upcaster) error
+
+	// Subject
+	ShredSubject(subject string) error
 
-	// Iterator
-	FetchFromIterator(batch int, id string) ([]*Event, error)
-	CloseIterator(id string) error
+	// Admin
+	DeleteEvent(id ulid.ULID) error
+	DeleteEvents(topic string, from time.Time, to time.Time) (int, error)
+	RedactEvent(id ulid.ULID, reason string) error
+	Status(ctx context.Context) *Status
+}
+
+// Status is the state of the service and of its repository.
+type Status struct {
+	Namespace     string `json:"namespace"`
+	Driver        string `json:"driver,omitempty"`
+	Healthy       bool   `json:"healthy"`
+	Error         string `json:"error,omitempty"`
+	OpenIterators int    `json:"open_iterators"`
+	Buffered      int    `json:"buffered"` // events not written yet
 }
 
 type ServiceMiddleware func(Service) Service
 
+type ServiceOption func(*service)
+
+// WithRetention enforces the retention policies with a background compactor,
+// when the repository is a Retainer.
+func WithRetention(policies ...RetentionPolicy) ServiceOption {
+	return func(svc *service) {
+		svc.retention = append(svc.retention, policies...)
+	}
+}
+
+// WithCompaction compacts the topics of the policies with a background
+// compactor, keeping only the latest event per key, when the repository is a
+// Compactor.
+func WithCompaction(policies ...CompactionPolicy) ServiceOption {
+	return func(svc *service) {
+		svc.compaction = append(svc.compaction, policies...)
+	}
+}
+
+// WithOpenIterators reports the number of open iterators to the gauge.
+func WithOpenIterators(g metrics.Gauge) ServiceOption {
+	return func(svc *service) {
+		svc.openIterators = g
+	}
+}
+
+// WithNamespace sets the namespace of the service, which is logged.
+func WithNamespace(name string) ServiceOption {
+	return func(svc *service) {
+		svc.namespace = name
+	}
+}
+
+// WithDriver sets the storage driver of the repository, which is reported by
+// the status.
+func WithDriver(driver StorageDriver) ServiceOption {
+	return func(svc *service) {
+		svc.driver = driver
+	}
+}
+
+// WithQuota limits the open iterators, beyond which requests fail with
+// ErrQuotaExceeded, and the size of the stored payloads, beyond which they
+// fail with ErrPayloadTooLarge.
+func WithQuota(q Quota) ServiceOption {
+	return func(svc *service) {
+		svc.quota = q
+	}
+}
+
+// WithIdleTimeout closes the iterators not fetched from within the timeout,
+// so iterators abandoned by their clients do not run forever. Each fetch
+// renews the lease of an iterator; zero or a negative timeout never expires.
+func WithIdleTimeout(d time.Duration) ServiceOption {
+	return func(svc *service) {
+		svc.idleTimeout = d
+	}
+}
+
+// WithMaxIteratorsPerClient limits the open iterators of each owner, see
+// WithOwner; further iterators fail with ErrQuotaExceeded.
+func WithMaxIteratorsPerClient(n int) ServiceOption {
+	return func(svc *service) {
+		svc.maxPerClient = n
+	}
+}
+
+// WithCompactionInterval sets how often the background compactor runs.
+func WithCompactionInterval(d time.Duration) ServiceOption {
+	return func(svc *service) {
+		svc.compactionInterval = d
+	}
+}
+
 type service struct {
 	log       *zap.Logger
 	events    Repository
+	schemas   *SchemaRegistry
+	upcasters *UpcasterRegistry
 	iterators sync.Map
+	namespace string
+	driver    StorageDriver
+	quota     Quota
+
+	open          atomic.Int64 // open iterators, enforcing the quota
+	openIterators metrics.Gauge
+	idleTimeout   time.Duration
+	maxPerClient  int
+	owners        map[string]int // open iterators per owner
+	mu            sync.Mutex     // guards owners
+
+	retention          []RetentionPolicy
+	compaction         []CompactionPolicy
+	compactionInterval time.Duration
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewService(events Repository) Service {
-	return &service{
-		events: events,
+func NewService(events Repository, opts ...ServiceOption) Service {
+	svc := &service{
+		events:             events,
+		schemas:            NewSchemaRegistry(),
+		upcasters:          NewUpcasterRegistry(),
+		namespace:          DefaultNamespace,
+		compactionInterval: time.Minute,
+		openIterators:      discard.NewGauge(),
+		owners:             make(map[string]int),
 	}
+
+	for _, opt := range opts {
+		opt(svc)
+	}
+
+	return svc
 }
 
 func (svc *service) Up() {
 	svc.log = zap.L().With(
 		zap.String("service", "events"),
+		zap.String("namespace", svc.namespace),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	svc.ctx = ctx
 	svc.cancel = cancel
 
+	if len(svc.retention) > 0 || len(svc.compaction) > 0 {
+		go svc.compactionHandler(ctx)
+	}
+
+	if svc.idleTimeout > 0 {
+		go svc.expiryHandler(ctx)
+	}
+
 	svc.log.Info("done", zap.String("action", "up"))
 }
 
+func (svc *service) compactionHandler(ctx context.Context) {
+	log := svc.log.With(
+		zap.String("handler", "compaction"),
+	)
+
+	retainer, ok := svc.events.(Retainer)
+	if !ok && len(svc.retention) > 0 {
+		log.Warn("retention not supported by repository")
+	}
+
+	compactor, ok := svc.events.(Compactor)
+	if !ok && len(svc.compaction) > 0 {
+		log.Warn("compaction not supported by repository")
+	}
+
+	if retainer == nil && compactor == nil {
+		return
+	}
+
+	ticker := time.NewTicker(svc.compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("done")
+			return
+
+		case <-ticker.C:
+			if retainer != nil {
+				svc.retain(log, retainer)
+			}
+
+			if compactor != nil {
+				svc.compact(log, compactor)
+			}
+		}
+	}
+}
+
+func (svc *service) retain(log *zap.Logger, retainer Retainer) {
+	for _, policy := range svc.retention {
+		log := log.With(
+			zap.String("topic", policy.Topic),
+			zap.Duration("max_age", policy.MaxAge),
+			zap.Int("max_count", policy.MaxCount),
+		)
+
+		n, err := retainer.Retain(policy)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+
+		if n > 0 {
+			log.Info("events deleted", zap.Int("size", n))
+		}
+	}
+}
+
+func (svc *service) compact(log *zap.Logger, compactor Compactor) {
+	for _, policy := range svc.compaction {
+		log := log.With(
+			zap.String("topic", policy.Topic),
+			zap.Duration("delete_retention", policy.DeleteRetention),
+		)
+
+		n, err := compactor.Compact(policy)
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+
+		if n > 0 {
+			log.Info("events compacted", zap.Int("size", n))
+		}
+	}
+}
+
 func (svc *service) Down() {
 	svc.cancel()
 	svc.log.Info("done", zap.String("action", "down"))
 }
 
-func (svc *service) Store(topic string, payload Payload, ids ...ulid.ULID) error {
-	e := NewEvent(topic, payload, ids...)
+func (svc *service) Store(e *Event) error {
+	if max := svc.quota.MaxEventBytes; max > 0 {
+		size, err := payloadSize(e.Payload)
+		if err != nil {
+			return err
+		}
+
+		if size > max {
+			return fmt.Errorf("%w: %d bytes exceed %d bytes", ErrPayloadTooLarge, size, max)
+		}
+	}
+
+	e.Version = svc.upcasters.Version(e.Topic)
+
 	err := svc.events.Store(e)
 	if err != nil {
 		return err
@@ -72,75 +310,354 @@ func (svc *service) Store(topic string, payload Payload, ids ...ulid.ULID) error
 	return nil
 }
 
-func (svc *service) NewIterator(topic string, since time.Time) (string, error) {
-	// TODO: options
+func (svc *service) NewIterator(topic string, since time.Time, opts ...IteratorOption) (string, error) {
+	l := newLease(topic, opts...)
+
+	if err := svc.reserve(l.owner); err != nil {
+		return "", err
+	}
+
 	it, err := svc.events.Iterator(svc.ctx, since)
 	if err != nil {
+		svc.unreserve(l.owner)
 		return "", err
 	}
 
-	go svc.doneHandler(it)
+	l.Iterator = it
+
+	go svc.doneHandler(l)
+
+	svc.iterators.Store(it.ID(), l)
+	svc.openIterators.Add(1)
 
-	svc.iterators.Store(it.ID(), it)
 	return it.ID(), nil
 }
 
-func (svc *service) Iterator(id string) (Iterator, error) {
+// reserve reserves an iterator of the owner first, so concurrent requests
+// cannot exceed the quotas.
+func (svc *service) reserve(owner string) error {
+	n := svc.open.Add(1)
+	if max := svc.quota.MaxIterators; max > 0 && n > int64(max) {
+		svc.open.Add(-1)
+		return fmt.Errorf("%w: %d open iterators", ErrQuotaExceeded, max)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if max := svc.maxPerClient; max > 0 && svc.owners[owner] >= max {
+		svc.open.Add(-1)
+		return fmt.Errorf("%w: %d open iterators of %s", ErrQuotaExceeded, max, owner)
+	}
+
+	svc.owners[owner]++
+	return nil
+}
+
+func (svc *service) unreserve(owner string) {
+	svc.open.Add(-1)
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if svc.owners[owner]--; svc.owners[owner] <= 0 {
+		delete(svc.owners, owner)
+	}
+}
+
+// lease returns the open iterator of the owner; iterators of other clients
+// are not found, so their IDs are not disclosed. An empty owner, e.g. of
+// internal callers, matches any iterator.
+func (svc *service) lease(id string, owner string) (*lease, error) {
 	val, ok := svc.iterators.Load(id)
 	if !ok {
-		return nil, errors.New("iterator not found")
+		return nil, ErrIteratorNotFound
+	}
+
+	l, ok := val.(*lease)
+	if !ok {
+		return nil, ErrInvalidType
 	}
 
-	it, ok := val.(Iterator)
+	if owner != "" && owner != l.owner {
+		return nil, ErrIteratorNotFound
+	}
+
+	return l, nil
+}
+
+// release removes the lease of a closed iterator, reporting whether it was
+// still open.
+func (svc *service) release(id string) (*lease, bool) {
+	val, ok := svc.iterators.LoadAndDelete(id)
 	if !ok {
-		return nil, errors.New("invalid type")
+		return nil, false
+	}
+
+	l := val.(*lease)
+	svc.unreserve(l.owner)
+	svc.openIterators.Add(-1)
+
+	return l, true
+}
+
+func (svc *service) Iterator(id string) (Iterator, error) {
+	l, err := svc.lease(id, "")
+	if err != nil {
+		return nil, err
 	}
 
-	return it, nil
+	return l.Iterator, nil
 }
 
-func (svc *service) doneHandler(it Iterator) {
+// Iterators lists the open iterators of the owner, or of every owner when
+// empty, oldest first.
+func (svc *service) Iterators(owner string) []*IteratorInfo {
+	infos := make([]*IteratorInfo, 0)
+	svc.iterators.Range(func(key, val any) bool {
+		l, ok := val.(*lease)
+		if ok && (owner == "" || l.owner == owner) {
+			infos = append(infos, l.info(svc.idleTimeout))
+		}
+
+		return true
+	})
+
+	slices.SortFunc(infos, func(a, b *IteratorInfo) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return infos
+}
+
+func (svc *service) doneHandler(l *lease) {
 	log := svc.log.With(
-		zap.String("iterator", it.ID()),
+		zap.String("iterator", l.ID()),
 		zap.String("handler", "iterator_done"),
 	)
 
-	<-it.Done()
-	if err := it.Err(); err != nil {
+	<-l.Done()
+	if err := l.Err(); err != nil {
 		if !errors.Is(err, context.Canceled) {
 			log.Error(err.Error())
 		}
 	}
 
-	svc.iterators.Delete(it.ID())
+	svc.release(l.ID())
+
 	log.Info("done")
 }
 
-func (svc *service) FetchFromIterator(batch int, id string) ([]*Event, error) {
-	val, ok := svc.iterators.Load(id)
+// expiryHandler closes the iterators idle for the idle timeout.
+func (svc *service) expiryHandler(ctx context.Context) {
+	log := svc.log.With(
+		zap.String("handler", "iterator_expiry"),
+	)
+
+	interval := max(min(svc.idleTimeout/2, time.Minute), time.Millisecond)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("done")
+			return
+
+		case now := <-ticker.C:
+			svc.expire(log, now)
+		}
+	}
+}
+
+func (svc *service) expire(log *zap.Logger, now time.Time) {
+	svc.iterators.Range(func(key, val any) bool {
+		l, ok := val.(*lease)
+		if !ok {
+			return true
+		}
+
+		idle := l.idle(now)
+		if idle < svc.idleTimeout {
+			return true
+		}
+
+		if _, ok := svc.release(l.ID()); ok {
+			l.Close(ErrIteratorExpired)
+
+			log.Info("iterator expired",
+				zap.String("iterator", l.ID()),
+				zap.String("owner", l.owner),
+				zap.Duration("idle", idle),
+			)
+		}
+
+		return true
+	})
+}
+
+func (svc *service) FetchFromIterator(batch int, id string, owner string) ([]*Event, error) {
+	l, err := svc.lease(id, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	l.renew()
+
+	// events of a failed upcast are fetched again, since the iterator has
+	// already moved past them
+	events := l.take()
+	if len(events) == 0 {
+		fetched, err := l.Fetch(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		// events of other topics are skipped; reserved topics, e.g. the audit
+		// trail, are only fetched by iterators of their own. The fetched slice
+		// may be backed by the repository, so it is filtered into a copy.
+		events = make([]*Event, 0, len(fetched))
+		for _, e := range fetched {
+			if l.matches(e.Topic) {
+				events = append(events, e)
+			}
+		}
+	}
+
+	es := make([]*Event, 0, len(events))
+	for i, e := range events {
+		upcasted, err := svc.upcasters.Upcast(e)
+		if err == nil {
+			es = append(es, upcasted)
+			continue
+		}
+
+		// an event failing every attempt is skipped, so it cannot wedge the
+		// iterator
+		if l.fail(e.ID) >= maxUpcastAttempts {
+			svc.log.Error("event skipped",
+				zap.String("iterator", id),
+				zap.String("event", e.ID.String()),
+				zap.String("topic", e.Topic),
+				zap.Error(err),
+			)
+
+			continue
+		}
+
+		// the events upcasted so far are delivered, the others held back
+		l.hold(events[i:])
+		if len(es) == 0 {
+			return nil, err
+		}
+
+		break
+	}
+
+	l.advance(es)
+
+	return es, nil
+}
+
+func (svc *service) CloseIterator(id string, owner string) error {
+	if _, err := svc.lease(id, owner); err != nil {
+		return err
+	}
+
+	l, ok := svc.release(id)
 	if !ok {
-		return nil, ErrIteratorNotFound
+		return ErrIteratorNotFound
 	}
 
-	it, ok := val.(Iterator)
+	l.Close(nil)
+	return nil
+}
+
+func (svc *service) RegisterSchema(topic string, schema []byte) error {
+	return svc.schemas.Register(topic, schema)
+}
+
+func (svc *service) ValidatePayload(topic string, payload Payload) error {
+	return svc.schemas.Validate(topic, payload)
+}
+
+func (svc *service) RegisterUpcaster(topic string, version int, up Upcaster) error {
+	return svc.upcasters.Register(topic, version, up)
+}
+
+func (svc *service) ShredSubject(subject string) error {
+	shredder, ok := svc.events.(Shredder)
 	if !ok {
-		return nil, ErrInvalidType
+		return ErrNotSupported
 	}
 
-	return it.Fetch(batch)
+	return shredder.ShredSubject(subject)
 }
 
-func (svc *service) CloseIterator(id string) error {
-	val, ok := svc.iterators.LoadAndDelete(id)
+func (svc *service) DeleteEvent(id ulid.ULID) error {
+	eraser, ok := svc.events.(Eraser)
 	if !ok {
-		return ErrIteratorNotFound
+		return ErrNotSupported
 	}
 
-	it, ok := val.(Iterator)
+	return eraser.Delete(id)
+}
+
+// DeleteEvents deletes the events of matching topics within the time range;
+// a zero end time deletes every event since the start time.
+func (svc *service) DeleteEvents(topic string, from time.Time, to time.Time) (int, error) {
+	eraser, ok := svc.events.(Eraser)
 	if !ok {
-		return ErrInvalidType
+		return 0, ErrNotSupported
 	}
 
-	it.Close(nil)
-	return nil
+	if to.IsZero() {
+		to = time.Now().Add(time.Millisecond)
+	}
+
+	return eraser.DeleteRange(topic, from, to)
+}
+
+func (svc *service) RedactEvent(id ulid.ULID, reason string) error {
+	eraser, ok := svc.events.(Eraser)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return eraser.Redact(id, reason)
+}
+
+// Status reports the open iterators, and the health and buffered events of
+// the repository, when it supports them.
+func (svc *service) Status(ctx context.Context) *Status {
+	status := &Status{
+		Namespace:     svc.namespace,
+		Driver:        string(svc.driver),
+		Healthy:       true,
+		OpenIterators: int(svc.open.Load()),
+	}
+
+	if checker, ok := svc.events.(HealthChecker); ok {
+		if err := checker.Health(ctx); err != nil {
+			status.Healthy = false
+			status.Error = err.Error()
+		}
+	}
+
+	if buffered, ok := svc.events.(Buffered); ok {
+		status.Buffered = buffered.Buffered()
+	}
+
+	return status
+}
+
+// payloadSize returns the size of the payload as stored, see Payload.Raw.
+func payloadSize(p Payload) (int, error) {
+	raw, err := p.Raw()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(raw), nil
 }
@@ -0,0 +1,32 @@
+package events
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchTopic reports whether the topic matches the pattern. Patterns use the
+// shell-style syntax of path.Match, e.g. "sensors/*" or "hello.*"; a trailing
+// "**" matches any suffix, including "/" separators. Reserved topics only
+// match patterns starting with a $ too, so wildcards never select the events
+// of the store itself.
+func MatchTopic(pattern string, topic string) bool {
+	if IsReservedTopic(topic) && !IsReservedTopic(pattern) {
+		return false
+	}
+
+	if pattern == topic {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "**"); ok {
+		return strings.HasPrefix(topic, prefix)
+	}
+
+	matched, err := path.Match(pattern, topic)
+	if err != nil {
+		return false
+	}
+
+	return matched
+}
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mirror520/events"
+
+var traceContext = propagation.TraceContext{}
+
+// traceParent returns the W3C traceparent of the span of the context, or an
+// empty string when the context has no valid span.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+
+	return carrier.Get("traceparent")
+}
+
+// remoteSpanContext parses a W3C traceparent; the span context is invalid
+// when the traceparent is empty or malformed.
+func remoteSpanContext(traceparent string) trace.SpanContext {
+	if traceparent == "" {
+		return trace.SpanContext{}
+	}
+
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	ctx := traceContext.Extract(context.Background(), carrier)
+
+	return trace.SpanContextFromContext(ctx)
+}
+
+// TracingMiddleware creates spans for storing and fetching events. A stored
+// event continues the trace of its traceparent, which is then replaced with
+// the span of the store, so consumers can follow the event back to its
+// producer. Fetched events are linked to the span of the fetch.
+func TracingMiddleware(tp trace.TracerProvider) ServiceMiddleware {
+	return func(next Service) Service {
+		return &tracingMiddleware{tp.Tracer(tracerName), next}
+	}
+}
+
+type tracingMiddleware struct {
+	tracer trace.Tracer
+	next   Service
+}
+
+func (mw *tracingMiddleware) Up() {
+	mw.next.Up()
+}
+
+func (mw *tracingMiddleware) Down() {
+	mw.next.Down()
+}
+
+func (mw *tracingMiddleware) Store(e *Event) error {
+	ctx := context.Background()
+	if sc := remoteSpanContext(e.TraceParent); sc.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("event.id", e.ID.String()),
+		attribute.String("event.topic", e.Topic),
+	}
+
+	if e.Key != "" {
+		attrs = append(attrs, attribute.String("event.key", e.Key))
+	}
+
+	ctx, span := mw.tracer.Start(ctx, "Store",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	e.TraceParent = traceParent(ctx)
+
+	err := mw.next.Store(e)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+func (mw *tracingMiddleware) NewIterator(topic string, since time.Time, opts ...IteratorOption) (string, error) {
+	_, span := mw.tracer.Start(context.Background(), "NewIterator",
+		trace.WithAttributes(
+			attribute.String("event.topic", topic),
+			attribute.String("iterator.since", since.Format(time.RFC3339Nano)),
+		),
+	)
+	defer span.End()
+
+	id, err := mw.next.NewIterator(topic, since, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return id, err
+	}
+
+	span.SetAttributes(attribute.String("iterator.id", id))
+	return id, nil
+}
+
+func (mw *tracingMiddleware) Iterator(id string) (Iterator, error) {
+	return mw.next.Iterator(id)
+}
+
+func (mw *tracingMiddleware) Iterators(owner string) []*IteratorInfo {
+	return mw.next.Iterators(owner)
+}
+
+func (mw *tracingMiddleware) FetchFromIterator(batch int, id string, owner string) ([]*Event, error) {
+	_, span := mw.tracer.Start(context.Background(), "FetchFromIterator",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("iterator.id", id),
+			attribute.Int("iterator.batch", batch),
+		),
+	)
+	defer span.End()
+
+	es, err := mw.next.FetchFromIterator(batch, id, owner)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return es, err
+	}
+
+	for _, e := range es {
+		if sc := remoteSpanContext(e.TraceParent); sc.IsValid() {
+			span.AddLink(trace.Link{
+				SpanContext: sc,
+				Attributes: []attribute.KeyValue{
+					attribute.String("event.id", e.ID.String()),
+				},
+			})
+		}
+	}
+
+	span.SetAttributes(attribute.Int("iterator.events", len(es)))
+	return es, nil
+}
+
+func (mw *tracingMiddleware) CloseIterator(id string, owner string) error {
+	return mw.next.CloseIterator(id, owner)
+}
+
+func (mw *tracingMiddleware) RegisterSchema(topic string, schema []byte) error {
+	return mw.next.RegisterSchema(topic, schema)
+}
+
+func (mw *tracingMiddleware) ValidatePayload(topic string, payload Payload) error {
+	return mw.next.ValidatePayload(topic, payload)
+}
+
+func (mw *tracingMiddleware) RegisterUpcaster(topic string, version int, up Upcaster) error {
+	return mw.next.RegisterUpcaster(topic, version, up)
+}
+
+func (mw *tracingMiddleware) ShredSubject(subject string) error {
+	return mw.next.ShredSubject(subject)
+}
+
+func (mw *tracingMiddleware) DeleteEvent(id ulid.ULID) error {
+	return mw.next.DeleteEvent(id)
+}
+
+func (mw *tracingMiddleware) DeleteEvents(topic string, from time.Time, to time.Time) (int, error) {
+	return mw.next.DeleteEvents(topic, from, to)
+}
+
+func (mw *tracingMiddleware) RedactEvent(id ulid.ULID, reason string) error {
+	return mw.next.RedactEvent(id, reason)
+}
+
+func (mw *tracingMiddleware) Status(ctx context.Context) *Status {
+	return mw.next.Status(ctx)
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	next := new(stubService)
+	svc := TracingMiddleware(tp)(next)
+
+	// a request of the producer
+	ctx, producer := tp.Tracer("test").Start(context.Background(), "producer")
+
+	endpoint := StoreEndpoint(svc)
+	_, err := endpoint(ctx, StoreRequest{
+		Topic:   "hello.world",
+		Payload: NewPayload("Hello World"),
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	producer.End()
+
+	spans := exporter.GetSpans()
+	if !assert.Len(spans, 2) {
+		return
+	}
+
+	store := spans[0]
+	assert.Equal("Store", store.Name)
+	assert.Equal(trace.SpanKindProducer, store.SpanKind)
+	assert.Equal(producer.SpanContext().SpanID(), store.Parent.SpanID())
+
+	// the stored event carries the span of the store
+	if !assert.Len(next.stored, 1) {
+		return
+	}
+
+	e := next.stored[0]
+	sc := remoteSpanContext(e.TraceParent)
+	assert.True(sc.IsValid())
+	assert.Equal(store.SpanContext.TraceID(), sc.TraceID())
+	assert.Equal(store.SpanContext.SpanID(), sc.SpanID())
+
+	exporter.Reset()
+
+	// a consumer fetches the event, and an event without trace context
+	next.es = []*Event{e, NewEvent("hello.world", NewPayload("Hello World"))}
+
+	es, err := svc.FetchFromIterator(100, "iterator", "")
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Len(es, 2)
+
+	spans = exporter.GetSpans()
+	if !assert.Len(spans, 1) {
+		return
+	}
+
+	fetch := spans[0]
+	assert.Equal("FetchFromIterator", fetch.Name)
+	assert.Equal(trace.SpanKindConsumer, fetch.SpanKind)
+	assert.NotEqual(store.SpanContext.TraceID(), fetch.SpanContext.TraceID())
+
+	if assert.Len(fetch.Links, 1) {
+		assert.Equal(store.SpanContext.SpanID(), fetch.Links[0].SpanContext.SpanID())
+	}
+}
+
+func TestStoreEndpointTraceParent(t *testing.T) {
+	assert := assert.New(t)
+
+	next := new(stubService)
+	endpoint := StoreEndpoint(next)
+
+	// an explicit trace context is kept
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	endpoint(context.Background(), StoreRequest{
+		Topic:       "hello.world",
+		Payload:     NewPayload("Hello World"),
+		TraceParent: traceparent,
+	})
+
+	// without a span, no trace context is recorded
+	endpoint(context.Background(), StoreRequest{
+		Topic:   "hello.world",
+		Payload: NewPayload("Hello World"),
+	})
+
+	if assert.Len(next.stored, 2) {
+		assert.Equal(traceparent, next.stored[0].TraceParent)
+		assert.Empty(next.stored[1].TraceParent)
+	}
+}
@@ -0,0 +1,25 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/mirror520/events"
+)
+
+// ActorMiddleware sets the client of the request as the actor of its
+// context, for the audit records of endpoints. Like spans, the actor is seen
+// by endpoints only when the engine has ContextWithFallback enabled. The
+// address of the client is only taken from the X-Forwarded-For of the trusted
+// proxies of the engine, see gin.Engine.SetTrustedProxies.
+func ActorMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		actor := events.ActorFromContext(ctx.Request.Context())
+		actor.RemoteAddr = ctx.ClientIP()
+		actor.UserAgent = ctx.Request.UserAgent()
+
+		c := events.ContextWithActor(ctx.Request.Context(), actor)
+		ctx.Request = ctx.Request.WithContext(c)
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func TestActorMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.SetTrustedProxies([]string{"10.0.0.0/8"})
+	r.Use(ActorMiddleware())
+
+	var actor events.Actor
+	r.DELETE("/v1/events/iterators/:id", func(ctx *gin.Context) {
+		actor = events.ActorFromContext(ctx)
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/events/iterators/123", nil)
+	req.RemoteAddr = "10.0.0.1:54321"
+	req.Header.Set("User-Agent", "consumer/1.0")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal("anonymous", actor.Principal)
+	assert.Equal("10.0.0.1", actor.RemoteAddr)
+	assert.Equal("consumer/1.0", actor.UserAgent)
+
+	// untrusted clients may not forge their address
+	req.RemoteAddr = "192.0.2.1:54321"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal("192.0.2.1", actor.RemoteAddr)
+
+	// trusted proxies forward the address of their clients
+	req.RemoteAddr = "10.0.0.2:54321"
+
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal("203.0.113.7", actor.RemoteAddr)
+}
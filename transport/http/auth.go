@@ -0,0 +1,36 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mirror520/events/auth"
+)
+
+// AuthMiddleware extracts the credential of the request, from an
+// Authorization header of the ApiKey or Bearer scheme, or from an X-API-Key
+// header, for the auth.Middleware of the endpoints. Like spans, the
+// credential is seen by endpoints only when the engine has
+// ContextWithFallback enabled.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var cred auth.Credential
+
+		if header := ctx.GetHeader("Authorization"); header != "" {
+			scheme, token, _ := strings.Cut(header, " ")
+			cred.Scheme = scheme
+			cred.Token = strings.TrimSpace(token)
+		} else if key := ctx.GetHeader("X-API-Key"); key != "" {
+			cred.Scheme = auth.SchemeAPIKey
+			cred.Token = key
+		}
+
+		if cred.Token != "" {
+			c := auth.ContextWithCredential(ctx.Request.Context(), cred)
+			ctx.Request = ctx.Request.WithContext(c)
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/auth"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	authenticator, err := auth.NewAuthenticator(events.Auth{
+		APIKeys: []events.APIKey{
+			{Principal: "consumer", Key: "secret"},
+		},
+	})
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(AuthMiddleware())
+
+	endpoint := auth.Middleware(authenticator, nil)(func(ctx context.Context, request any) (any, error) {
+		return nil, nil
+	})
+
+	r.DELETE("/v1/events/iterators/:id", CloseIteratorHandler(endpoint))
+
+	for _, tc := range []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"Authorization", "ApiKey wrong", http.StatusUnauthorized},
+		{"Authorization", "ApiKey secret", http.StatusOK},
+		{"X-API-Key", "secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/v1/events/iterators/123", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(tc.status, w.Code, tc.header+": "+tc.value)
+	}
+}
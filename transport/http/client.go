@@ -0,0 +1,184 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mirror520/events"
+	"github.com/mirror520/events/auth"
+	"github.com/mirror520/events/model"
+)
+
+// knownErrors are the errors recognized by the client from the message of a
+// failure result, so callers can tell them apart with errors.Is.
+var knownErrors = []error{
+	events.ErrEventEmpty,
+	events.ErrTimeout,
+	events.ErrIteratorNotFound,
+}
+
+// Client calls the iterator API of a remote events instance. Events are
+// fetched as MessagePack records, which keep the DataType of their payloads.
+type Client struct {
+	baseURL string
+	client  *http.Client
+	codec   events.Codec
+	apiKey  string
+}
+
+type ClientOption func(*Client)
+
+// WithAPIKey authenticates the requests with the API key.
+func WithAPIKey(key string) ClientOption {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+func NewClient(baseURL string, client *http.Client, opts ...ClientOption) (*Client, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	codec, err := events.CodecByName("msgpack")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+		codec:   codec,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+func (c *Client) NewIterator(ctx context.Context, topic string, since time.Time) (string, error) {
+	body, err := json.Marshal(events.NewIteratorRequest{
+		Topic: topic,
+		Since: since,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/v1/events/iterators", bytes.NewReader(body), gin.MIMEJSON)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	result, err := decodeResult(resp)
+	if err != nil {
+		return "", err
+	}
+
+	id, ok := result.Data.(string)
+	if !ok {
+		return "", events.ErrInvalidType
+	}
+
+	return id, nil
+}
+
+func (c *Client) FetchFromIterator(ctx context.Context, id string, batch int) ([]*events.Event, error) {
+	path := "/v1/events/iterators/" + url.PathEscape(id) + "?batch=" + strconv.Itoa(batch)
+
+	resp, err := c.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, err := decodeResult(resp)
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*events.Record
+	if err := c.codec.Unmarshal(data, &rs); err != nil {
+		return nil, err
+	}
+
+	es := make([]*events.Event, len(rs))
+	for i, r := range rs {
+		e, err := r.Event()
+		if err != nil {
+			return nil, err
+		}
+
+		es[i] = e
+	}
+
+	return es, nil
+}
+
+func (c *Client) CloseIterator(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/v1/events/iterators/"+url.PathEscape(id), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = decodeResult(resp)
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	req.Header.Set("Accept", c.codec.ContentType())
+
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", auth.SchemeAPIKey+" "+c.apiKey)
+	}
+
+	return c.client.Do(req)
+}
+
+func decodeResult(resp *http.Response) (*model.Result, error) {
+	var result *model.Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	if result.Status != model.SUCCESS {
+		for _, err := range knownErrors {
+			if result.Msg == err.Error() {
+				return nil, err
+			}
+		}
+
+		return nil, errors.New(result.Msg)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,289 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mirror520/events"
+)
+
+// ref: https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsSource      = "/v1/events"
+
+	CloudEventsContentType      = "application/cloudevents+json"
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+var (
+	ErrInvalidSpecVersion  = errors.New("invalid specversion")
+	ErrInvalidCloudEvent   = errors.New("invalid cloudevent")
+	ErrInvalidCloudEventID = errors.New("invalid cloudevent id, expected a ULID")
+)
+
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+
+	// ref: https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/extensions/partitioning.md
+	PartitionKey string `json:"partitionkey,omitempty"`
+
+	// ref: https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/extensions/distributed-tracing.md
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+func NewCloudEvent(e *events.Event) (*CloudEvent, error) {
+	ts := e.Time().UTC()
+
+	ce := &CloudEvent{
+		SpecVersion:  CloudEventsSpecVersion,
+		ID:           e.ID.String(),
+		Source:       CloudEventsSource,
+		Type:         e.Topic,
+		Subject:      e.Subject,
+		Time:         &ts,
+		PartitionKey: e.Key,
+		TraceParent:  e.TraceParent,
+	}
+
+	switch e.Payload.Type {
+	case events.Bytes, events.Protobuf, events.MessagePack, events.CBOR:
+		bs, ok := e.Payload.Bytes()
+		if !ok {
+			return nil, events.ErrInvalidType
+		}
+
+		ce.DataContentType = e.Payload.ContentType()
+		ce.DataSchema = e.Payload.TypeURL
+		ce.DataBase64 = base64.StdEncoding.EncodeToString(bs)
+
+	default:
+		data, err := json.Marshal(&e.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		ce.DataContentType = "application/json"
+		ce.Data = data
+	}
+
+	return ce, nil
+}
+
+func (ce *CloudEvent) Validate() error {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return ErrInvalidSpecVersion
+	}
+
+	if ce.ID == "" || ce.Source == "" || ce.Type == "" {
+		return ErrInvalidCloudEvent
+	}
+
+	if len(ce.Data) > 0 && ce.DataBase64 != "" {
+		return ErrInvalidCloudEvent
+	}
+
+	return nil
+}
+
+// StoreRequest maps the CloudEvent onto a StoreRequest: the type becomes the
+// topic, and the id, which must be a ULID, the event ID. Base64 data of a JSON
+// content type is stored as JSON.
+func (ce *CloudEvent) StoreRequest() (events.StoreRequest, error) {
+	var req events.StoreRequest
+	if err := ce.Validate(); err != nil {
+		return req, err
+	}
+
+	req.Topic = ce.Type
+	req.Subject = ce.Subject
+	req.Key = ce.PartitionKey
+	req.TraceParent = ce.TraceParent
+
+	id, err := ulid.ParseStrict(ce.ID)
+	if err != nil {
+		return req, ErrInvalidCloudEventID
+	}
+
+	req.ID = id
+
+	switch {
+	case ce.DataBase64 != "":
+		bs, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return req, err
+		}
+
+		// without a content type, base64 data are bytes
+		if ce.DataContentType != "" && isJSONContentType(ce.DataContentType) {
+			payload, err := events.NewPayloadFromBytes(bs)
+			if err != nil {
+				return req, err
+			}
+
+			req.Payload = payload
+			return req, nil
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(ce.DataContentType)
+		t := events.DataTypeOf(mediaType)
+		if err := req.Payload.SetTypedBytes(bs, t, ce.DataSchema); err != nil {
+			return req, err
+		}
+
+	case len(ce.Data) > 0:
+		if !isJSONContentType(ce.DataContentType) {
+			var text string
+			if err := json.Unmarshal(ce.Data, &text); err == nil {
+				req.Payload.SetData(text)
+				return req, nil
+			}
+		}
+
+		payload, err := events.NewPayloadFromBytes(ce.Data)
+		if err != nil {
+			return req, err
+		}
+
+		req.Payload = payload
+
+	default:
+		req.Payload.SetData(nil)
+	}
+
+	return req, nil
+}
+
+func isCloudEventsRequest(ctx *gin.Context) bool {
+	return ctx.ContentType() == CloudEventsContentType ||
+		ctx.GetHeader("ce-specversion") != ""
+}
+
+// bindCloudEvent reads a CloudEvent in structured or binary content mode.
+func bindCloudEvent(ctx *gin.Context) (*CloudEvent, error) {
+	if ctx.ContentType() == CloudEventsContentType {
+		var ce *CloudEvent
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&ce); err != nil {
+			return nil, err
+		}
+
+		// a null body decodes without error
+		if ce == nil {
+			return nil, ErrInvalidCloudEvent
+		}
+
+		return ce, nil
+	}
+
+	header := ctx.Request.Header
+
+	ce := &CloudEvent{
+		SpecVersion:     header.Get("ce-specversion"),
+		ID:              header.Get("ce-id"),
+		Source:          header.Get("ce-source"),
+		Type:            header.Get("ce-type"),
+		Subject:         header.Get("ce-subject"),
+		DataContentType: header.Get("Content-Type"),
+		DataSchema:      header.Get("ce-dataschema"),
+		PartitionKey:    header.Get("ce-partitionkey"),
+		TraceParent:     header.Get("ce-traceparent"),
+	}
+
+	if timeStr := header.Get("ce-time"); timeStr != "" {
+		ts, err := time.Parse(time.RFC3339Nano, timeStr)
+		if err != nil {
+			return nil, err
+		}
+
+		ce.Time = &ts
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return ce, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(ce.DataContentType)
+	switch {
+	case isJSONContentType(mediaType):
+		ce.Data = body
+
+	case strings.HasPrefix(mediaType, "text/"):
+		data, err := json.Marshal(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		ce.Data = data
+
+	default:
+		ce.DataBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	return ce, nil
+}
+
+func acceptsCloudEvents(ctx *gin.Context) bool {
+	if ctx.Query("format") == "cloudevents" {
+		return true
+	}
+
+	accept := ctx.GetHeader("Accept")
+	return strings.Contains(accept, CloudEventsBatchContentType)
+}
+
+func renderCloudEvents(ctx *gin.Context, es []*events.Event) error {
+	ces := make([]*CloudEvent, len(es))
+	for i, e := range es {
+		ce, err := NewCloudEvent(e)
+		if err != nil {
+			return err
+		}
+
+		ces[i] = ce
+	}
+
+	data, err := json.Marshal(ces)
+	if err != nil {
+		return err
+	}
+
+	ctx.Data(http.StatusOK, CloudEventsBatchContentType, data)
+	return nil
+}
+
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
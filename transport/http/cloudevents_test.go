@@ -0,0 +1,228 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func TestCloudEventStoreRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	// structured: json data
+	{
+		input := []byte(`{
+			"specversion": "1.0",
+			"id": "01HJJD04ZSE4T4SN6T7SVYBPNV",
+			"source": "/sensors/1",
+			"type": "hello.world",
+			"datacontenttype": "application/json",
+			"data": {"msg": "Hello World"}
+		}`)
+
+		var ce *CloudEvent
+		if err := json.Unmarshal(input, &ce); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		req, err := ce.StoreRequest()
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		raw, ok := req.Payload.JSON()
+		assert.True(ok)
+		assert.JSONEq(`{"msg": "Hello World"}`, string(raw))
+		assert.Equal("hello.world", req.Topic)
+		assert.Equal("01HJJD04ZSE4T4SN6T7SVYBPNV", req.ID.String())
+	}
+
+	// structured: base64 data
+	{
+		input := []byte(`{
+			"specversion": "1.0",
+			"id": "01HJJD04ZSE4T4SN6T7SVYBPNV",
+			"source": "/sensors/1",
+			"type": "hello.world",
+			"time": "2023-01-22T15:35:00Z",
+			"data_base64": "SGVsbG8gV29ybGQ="
+		}`)
+
+		var ce *CloudEvent
+		if err := json.Unmarshal(input, &ce); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		req, err := ce.StoreRequest()
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		bs, ok := req.Payload.Bytes()
+		assert.True(ok)
+		assert.Equal([]byte("Hello World"), bs)
+	}
+
+	// structured: base64 json data
+	{
+		input := []byte(`{
+			"specversion": "1.0",
+			"id": "01HJJD04ZSE4T4SN6T7SVYBPNV",
+			"source": "/sensors/1",
+			"type": "hello.world",
+			"datacontenttype": "application/json",
+			"data_base64": "eyJtc2ciOiAiSGVsbG8gV29ybGQifQ=="
+		}`)
+
+		var ce *CloudEvent
+		if err := json.Unmarshal(input, &ce); err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		req, err := ce.StoreRequest()
+		if err != nil {
+			assert.Fail(err.Error())
+			return
+		}
+
+		raw, ok := req.Payload.JSON()
+		assert.True(ok)
+		assert.JSONEq(`{"msg": "Hello World"}`, string(raw))
+	}
+
+	// non-ULID id
+	{
+		ce := &CloudEvent{
+			SpecVersion: "1.0",
+			ID:          "A234-1234-1234",
+			Source:      "/sensors/1",
+			Type:        "hello.world",
+		}
+
+		_, err := ce.StoreRequest()
+		assert.ErrorIs(err, ErrInvalidCloudEventID)
+	}
+
+	// invalid specversion
+	{
+		ce := &CloudEvent{
+			SpecVersion: "0.3",
+			ID:          "1",
+			Source:      "/sensors/1",
+			Type:        "hello.world",
+		}
+
+		_, err := ce.StoreRequest()
+		assert.ErrorIs(err, ErrInvalidSpecVersion)
+	}
+}
+
+func TestStoreHandlerWithBinaryCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	ack := make(chan events.StoreRequest, 1)
+	endpoint := func(ctx context.Context, request any) (any, error) {
+		ack <- request.(events.StoreRequest)
+		return nil, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/events", StoreHandler(endpoint))
+
+	body := bytes.NewBufferString(`[1,2,3]`)
+	req := httptest.NewRequest(http.MethodPut, "/events", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", "01HJJD04ZSE4T4SN6T7SVYBPNV")
+	req.Header.Set("ce-source", "/sensors/1")
+	req.Header.Set("ce-type", "hello.world")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+
+	request := <-ack
+	raw, ok := request.Payload.JSON()
+	assert.True(ok)
+	assert.Equal(`[1,2,3]`, string(raw))
+	assert.Equal("hello.world", request.Topic)
+}
+
+func TestStoreHandlerWithNullCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoint := func(ctx context.Context, request any) (any, error) {
+		return nil, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/events", StoreHandler(endpoint))
+
+	req := httptest.NewRequest(http.MethodPut, "/events", bytes.NewBufferString(`null`))
+	req.Header.Set("Content-Type", CloudEventsContentType)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), ErrInvalidCloudEvent.Error())
+}
+
+func TestStoreHandlerWithNonULIDCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoint := func(ctx context.Context, request any) (any, error) {
+		return nil, nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/events", StoreHandler(endpoint))
+
+	req := httptest.NewRequest(http.MethodPut, "/events", bytes.NewBufferString(`[1,2,3]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", "A234-1234-1234")
+	req.Header.Set("ce-source", "/sensors/1")
+	req.Header.Set("ce-type", "hello.world")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(w.Body.String(), ErrInvalidCloudEventID.Error())
+}
+
+func TestNewCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	payload, _ := events.NewPayloadFromBytes([]byte("Hello World"), true)
+	e := events.NewEvent("hello.world", payload)
+
+	ce, err := NewCloudEvent(e)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	assert.Equal(e.ID.String(), ce.ID)
+	assert.Equal("hello.world", ce.Type)
+	assert.Equal("SGVsbG8gV29ybGQ=", ce.DataBase64)
+	assert.Empty(ce.Data)
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+
+	"github.com/mirror520/events"
+)
+
+// isRecordRequest reports whether the request body is an events.Record
+// encoded with a binary codec, e.g. MessagePack or CBOR.
+func isRecordRequest(ctx *gin.Context) bool {
+	switch ctx.ContentType() {
+	case "application/json", "":
+		return false
+	}
+
+	_, err := events.CodecByContentType(ctx.ContentType())
+	return err == nil
+}
+
+func bindRecord(ctx *gin.Context) (events.StoreRequest, error) {
+	var req events.StoreRequest
+
+	codec, err := events.CodecByContentType(ctx.ContentType())
+	if err != nil {
+		return req, err
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return req, err
+	}
+
+	var r *events.Record
+	if err := codec.Unmarshal(body, &r); err != nil {
+		return req, err
+	}
+
+	if len(r.ID) > 0 {
+		if err := req.ID.UnmarshalBinary(r.ID); err != nil {
+			return req, err
+		}
+	}
+
+	payload, err := events.NewPayloadFromRaw(r.Type, r.Data, r.TypeURL)
+	if err != nil {
+		return req, err
+	}
+
+	req.Topic = r.Topic
+	req.Payload = payload
+	return req, nil
+}
+
+// isProtobufRequest reports whether the request body is a raw Protobuf
+// message, published with PUT /events?topic=<topic>.
+func isProtobufRequest(ctx *gin.Context) bool {
+	return events.DataTypeOf(ctx.ContentType()) == events.Protobuf
+}
+
+// bindProtobuf reads a raw Protobuf message. The type URL is taken from the
+// "type_url" query, or from the "messageType" parameter of the Content-Type.
+func bindProtobuf(ctx *gin.Context) (events.StoreRequest, error) {
+	var req events.StoreRequest
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return req, err
+	}
+
+	typeURL := ctx.Query("type_url")
+	if typeURL == "" {
+		_, params, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if messageType := params["messagetype"]; messageType != "" {
+			typeURL = "type.googleapis.com/" + messageType
+		}
+	}
+
+	if idStr := ctx.Query("id"); idStr != "" {
+		id, err := ulid.Parse(idStr)
+		if err != nil {
+			return req, err
+		}
+
+		req.ID = id
+	}
+
+	req.Topic = ctx.Query("topic")
+	err = req.Payload.SetTypedBytes(body, events.Protobuf, typeURL)
+	return req, err
+}
+
+// negotiateCodec returns the binary codec requested by the Accept header,
+// or false when the response should be rendered as JSON.
+func negotiateCodec(ctx *gin.Context) (events.Codec, bool) {
+	format := ctx.NegotiateFormat(gin.MIMEJSON, "application/msgpack", "application/cbor")
+	if format == "" || format == gin.MIMEJSON {
+		return nil, false
+	}
+
+	codec, err := events.CodecByContentType(format)
+	if err != nil {
+		return nil, false
+	}
+
+	return codec, true
+}
+
+func renderRecords(ctx *gin.Context, codec events.Codec, es []*events.Event) error {
+	rs := make([]*events.Record, len(es))
+	for i, e := range es {
+		r, err := events.NewRecord(e)
+		if err != nil {
+			return err
+		}
+
+		rs[i] = r
+	}
+
+	data, err := codec.Marshal(rs)
+	if err != nil {
+		return err
+	}
+
+	ctx.Data(http.StatusOK, codec.ContentType(), data)
+	return nil
+}
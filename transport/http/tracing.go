@@ -0,0 +1,57 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mirror520/events/transport/http"
+
+// TracingMiddleware creates a server span for each request, continuing the
+// trace of the W3C traceparent header. The span is set on the context of the
+// request; endpoints see it only when the engine has ContextWithFallback
+// enabled, since they receive the gin context.
+func TracingMiddleware(tp trace.TracerProvider) gin.HandlerFunc {
+	tracer := tp.Tracer(tracerName)
+	propagator := propagation.TraceContext{}
+
+	return func(ctx *gin.Context) {
+		req := ctx.Request
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = req.URL.Path
+		}
+
+		c := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		c, span := tracer.Start(c, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", req.URL.Path),
+			),
+		)
+		defer span.End()
+
+		ctx.Request = req.WithContext(c)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		if len(ctx.Errors) > 0 {
+			span.RecordError(ctx.Errors.Last())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(TracingMiddleware(tp))
+
+	var sc trace.SpanContext
+	r.GET("/v1/events/iterators/:id", func(ctx *gin.Context) {
+		sc = trace.SpanContextFromContext(ctx)
+		ctx.Status(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events/iterators/123", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(spans, 1) {
+		return
+	}
+
+	span := spans[0]
+	assert.Equal("GET /v1/events/iterators/:id", span.Name)
+	assert.Equal(trace.SpanKindServer, span.SpanKind)
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal("00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Equal(span.SpanContext.SpanID(), sc.SpanID())
+}
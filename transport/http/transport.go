@@ -1,20 +1,62 @@
 package http
 
 import (
+	"errors"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-kit/kit/endpoint"
+	"github.com/oklog/ulid/v2"
 
 	"github.com/mirror520/events"
+	"github.com/mirror520/events/auth"
 	"github.com/mirror520/events/model"
 )
 
 func StoreHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var request events.StoreRequest
-		if err := ctx.ShouldBind(&request); err != nil {
+		if isCloudEventsRequest(ctx) {
+			ce, err := bindCloudEvent(ctx)
+			if err != nil {
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+				return
+			}
+
+			request, err = ce.StoreRequest()
+			if err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, ErrInvalidCloudEventID) {
+					status = http.StatusUnprocessableEntity
+				}
+
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(status, result)
+				return
+			}
+		} else if isRecordRequest(ctx) {
+			req, err := bindRecord(ctx)
+			if err != nil {
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+				return
+			}
+
+			request = req
+		} else if isProtobufRequest(ctx) {
+			req, err := bindProtobuf(ctx)
+			if err != nil {
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+				return
+			}
+
+			request = req
+		} else if err := ctx.ShouldBind(&request); err != nil {
 			result := model.FailureResult(err)
 			ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
 			return
@@ -23,7 +65,18 @@ func StoreHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 		_, err := endpoint(ctx, request)
 		if err != nil {
 			result := model.FailureResult(err)
-			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, result)
+
+			var verr *events.ValidationError
+			if errors.As(err, &verr) {
+				result.Data = verr.Violations
+			}
+
+			var lerr *events.LimitError
+			if errors.As(err, &lerr) {
+				ctx.Header("Retry-After", retryAfter(lerr.RetryAfter))
+			}
+
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
 			return
 		}
 
@@ -44,7 +97,7 @@ func NewIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 		id, err := endpoint(ctx, request)
 		if err != nil {
 			result := model.FailureResult(err)
-			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, result)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
 			return
 		}
 
@@ -54,6 +107,21 @@ func NewIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 	}
 }
 
+func ListIteratorsHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		response, err := endpoint(ctx, nil)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
+			return
+		}
+
+		result := model.SuccessResult("iterators listed")
+		result.Data = response
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
 func FetchFromIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		request := events.FetchFromIteratorRequest{
@@ -75,7 +143,31 @@ func FetchFromIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 		response, err := endpoint(ctx, request)
 		if err != nil {
 			result := model.FailureResult(err)
-			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, result)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
+			return
+		}
+
+		codec, ok := negotiateCodec(ctx)
+		if ce := acceptsCloudEvents(ctx); ce || ok {
+			es, ok := response.([]*events.Event)
+			if !ok {
+				result := model.FailureResult(events.ErrInvalidType)
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, result)
+				return
+			}
+
+			var err error
+			if ce {
+				err = renderCloudEvents(ctx, es)
+			} else {
+				err = renderRecords(ctx, codec, es)
+			}
+
+			if err != nil {
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, result)
+			}
+
 			return
 		}
 
@@ -92,7 +184,7 @@ func CloseIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 		_, err := endpoint(ctx, id)
 		if err != nil {
 			result := model.FailureResult(err)
-			ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, result)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
 			return
 		}
 
@@ -100,3 +192,214 @@ func CloseIteratorHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
 		ctx.JSON(http.StatusOK, result)
 	}
 }
+
+func ShredSubjectHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		subject := ctx.Param("subject")
+
+		_, err := endpoint(ctx, subject)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
+			return
+		}
+
+		result := model.SuccessResult("subject shredded")
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+func DeleteEventHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := ulid.ParseStrict(ctx.Param("id"))
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+			return
+		}
+
+		_, err = endpoint(ctx, id)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, adminStatus(err)), result)
+			return
+		}
+
+		result := model.SuccessResult("event deleted")
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+func DeleteEventsHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request events.DeleteEventsRequest
+		if err := ctx.ShouldBindQuery(&request); err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+			return
+		}
+
+		n, err := endpoint(ctx, request)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, adminStatus(err)), result)
+			return
+		}
+
+		result := model.SuccessResult("events deleted")
+		result.Data = n
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+func RedactEventHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := ulid.ParseStrict(ctx.Param("id"))
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+			return
+		}
+
+		var request events.RedactEventRequest
+		if ctx.Request.ContentLength != 0 {
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				result := model.FailureResult(err)
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, result)
+				return
+			}
+		}
+
+		request.ID = id
+
+		_, err = endpoint(ctx, request)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, adminStatus(err)), result)
+			return
+		}
+
+		result := model.SuccessResult("event redacted")
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// HealthHandler answers the liveness probe: the process serves requests.
+func HealthHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		result := model.SuccessResult("ok")
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// ReadinessHandler answers the readiness probe with the status of every
+// namespace, failing with 503 when any is unhealthy.
+func ReadinessHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		response, err := endpoint(ctx, nil)
+		if err != nil {
+			result := model.FailureResult(err)
+			result.Data = response
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusServiceUnavailable), result)
+			return
+		}
+
+		result := model.SuccessResult("ready")
+		result.Data = response
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// StatusHandler answers the status of the namespace, healthy or not.
+func StatusHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, err := endpoint(ctx, nil)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusInternalServerError), result)
+			return
+		}
+
+		result := model.SuccessResult("status")
+		result.Data = status
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+// errorStatus returns the status of errors common to all endpoints, or the
+// given status otherwise.
+func errorStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, auth.ErrUnauthorized):
+		return http.StatusUnauthorized
+
+	case errors.Is(err, events.ErrForbidden):
+		return http.StatusForbidden
+
+	case errors.Is(err, events.ErrQuotaExceeded),
+		errors.Is(err, events.ErrRateLimited):
+		return http.StatusTooManyRequests
+
+	case errors.Is(err, events.ErrPayloadTooLarge):
+		return http.StatusRequestEntityTooLarge
+
+	case errors.Is(err, events.ErrBackpressure):
+		return http.StatusServiceUnavailable
+	}
+
+	return status
+}
+
+// retryAfter formats the delay as the seconds of a Retry-After header,
+// rounded up, so clients never retry too early.
+func retryAfter(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, events.ErrEventNotFound):
+		return http.StatusNotFound
+
+	case errors.Is(err, events.ErrNotSupported):
+		return http.StatusNotImplemented
+
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+func ReplicationStatusHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, err := endpoint(ctx, nil)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
+			return
+		}
+
+		result := model.SuccessResult("replication status")
+		result.Data = status
+		ctx.JSON(http.StatusOK, result)
+	}
+}
+
+func PromoteReplicaHandler(endpoint endpoint.Endpoint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, err := endpoint(ctx, nil)
+		if err != nil {
+			result := model.FailureResult(err)
+			ctx.AbortWithStatusJSON(errorStatus(err, http.StatusUnprocessableEntity), result)
+			return
+		}
+
+		result := model.SuccessResult("replica promoted")
+		result.Data = status
+		ctx.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mirror520/events"
+)
+
+func TestStoreHandlerRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.PUT("/v1/events", StoreHandler(func(ctx context.Context, request any) (any, error) {
+		return nil, &events.LimitError{
+			Err:        events.ErrRateLimited,
+			RetryAfter: 1500 * time.Millisecond,
+		}
+	}))
+
+	body := strings.NewReader(`{"topic":"hello.world","payload":{"msg":"Hello World"}}`)
+	req := httptest.NewRequest(http.MethodPut, "/v1/events", body)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("2", w.Header().Get("Retry-After"))
+}
+
+func TestStoreHandlerPayloadTooLarge(t *testing.T) {
+	assert := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.PUT("/v1/events", StoreHandler(func(ctx context.Context, request any) (any, error) {
+		return nil, fmt.Errorf("%w: 23 bytes exceed 16 bytes", events.ErrPayloadTooLarge)
+	}))
+
+	body := strings.NewReader(`{"topic":"hello.world","payload":{"msg":"Hello World"}}`)
+	req := httptest.NewRequest(http.MethodPut, "/v1/events", body)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// retrying cannot succeed
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(w.Header().Get("Retry-After"))
+}
+
+func TestReadinessHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	gin.SetMode(gin.TestMode)
+
+	var err error
+
+	r := gin.New()
+	r.GET("/readyz", ReadinessHandler(func(ctx context.Context, request any) (any, error) {
+		return []*events.Status{{Namespace: events.DefaultNamespace}}, err
+	}))
+
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{events.ErrUnhealthy, http.StatusServiceUnavailable},
+	} {
+		err = tc.err
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(tc.status, w.Code)
+		assert.Contains(w.Body.String(), `"namespace":"default"`)
+	}
+}
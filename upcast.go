@@ -0,0 +1,97 @@
+package events
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrInvalidVersion = errors.New("invalid version")
+)
+
+// Upcaster transforms a payload of one schema version into the next one.
+type Upcaster func(payload Payload) (Payload, error)
+
+// UpcasterRegistry keeps a chain of upcasters per topic. The current version
+// of a topic is one past the highest version an upcaster is registered for.
+type UpcasterRegistry struct {
+	chains map[string]map[int]Upcaster
+	sync.RWMutex
+}
+
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{
+		chains: make(map[string]map[int]Upcaster),
+	}
+}
+
+// Register adds an upcaster that transforms payloads of the topic from the
+// given version to version+1.
+func (r *UpcasterRegistry) Register(topic string, version int, up Upcaster) error {
+	if version < 0 {
+		return ErrInvalidVersion
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	chain, ok := r.chains[topic]
+	if !ok {
+		chain = make(map[int]Upcaster)
+		r.chains[topic] = chain
+	}
+
+	chain[version] = up
+	return nil
+}
+
+func (r *UpcasterRegistry) Version(topic string) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	current := 0
+	for version := range r.chains[topic] {
+		if version+1 > current {
+			current = version + 1
+		}
+	}
+
+	return current
+}
+
+// Upcast returns a copy of the event with its payload transformed to the
+// current version of its topic. The given event is left untouched, as are
+// erased payloads, which no upcaster could parse.
+func (r *UpcasterRegistry) Upcast(e *Event) (*Event, error) {
+	if e.Payload.IsErased() {
+		return e, nil
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	chain, ok := r.chains[e.Topic]
+	if !ok {
+		return e, nil
+	}
+
+	up, ok := chain[e.Version]
+	if !ok {
+		return e, nil
+	}
+
+	upcasted := *e
+	for ok {
+		payload, err := up(upcasted.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		upcasted.Payload = payload
+		upcasted.Version++
+
+		up, ok = chain[upcasted.Version]
+	}
+
+	return &upcasted, nil
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpcasterRegistryUpcast(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewUpcasterRegistry()
+
+	// v0 -> v1: rename "temp" to "temperature"
+	registry.Register("sensors", 0, func(payload Payload) (Payload, error) {
+		raw, _ := payload.JSON()
+
+		var data map[string]any
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return payload, err
+		}
+
+		data["temperature"] = data["temp"]
+		delete(data, "temp")
+
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return payload, err
+		}
+
+		return NewPayloadFromJSON(bs), nil
+	})
+
+	// v1 -> v2: add unit
+	registry.Register("sensors", 1, func(payload Payload) (Payload, error) {
+		raw, _ := payload.JSON()
+
+		var data map[string]any
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return payload, err
+		}
+
+		data["unit"] = "celsius"
+
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return payload, err
+		}
+
+		return NewPayloadFromJSON(bs), nil
+	})
+
+	assert.Equal(2, registry.Version("sensors"))
+	assert.Equal(0, registry.Version("hello.world"))
+
+	e := NewEvent("sensors", NewPayloadFromJSON(json.RawMessage(`{"temp":25}`)))
+
+	upcasted, err := registry.Upcast(e)
+	if err != nil {
+		assert.Fail(err.Error())
+		return
+	}
+
+	raw, ok := upcasted.Payload.JSON()
+	assert.True(ok)
+	assert.JSONEq(`{"temperature":25,"unit":"celsius"}`, string(raw))
+	assert.Equal(2, upcasted.Version)
+
+	// stored event is left untouched
+	assert.Equal(0, e.Version)
+	assert.Equal(e.ID, upcasted.ID)
+
+	raw, _ = e.Payload.JSON()
+	assert.JSONEq(`{"temp":25}`, string(raw))
+}
+
+func TestUpcasterRegistrySkipsErased(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewUpcasterRegistry()
+	registry.Register("sensors", 0, func(payload Payload) (Payload, error) {
+		return payload, errors.New("unparsable")
+	})
+
+	e := NewEvent("sensors", NewErasedPayload("shredded"))
+	assert.True(e.Payload.IsErased())
+
+	upcasted, err := registry.Upcast(e)
+	assert.NoError(err)
+	assert.Same(e, upcasted)
+
+	payload := NewPayloadFromJSON(json.RawMessage(`{"$erased":"x","temp":25}`))
+	assert.False(payload.IsErased())
+}